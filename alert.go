@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+)
+
+// alertKind is what to do on the drawer kick connector after a label, which
+// is often wired to a buzzer or light rather than a cash drawer
+type alertKind string
+
+const (
+	alertNone  alertKind = ""
+	alertPulse alertKind = "pulse" // a single drawer kick pulse
+	alertBeep  alertKind = "beep"  // a few short pulses for a buzzer
+)
+
+// Pulse timings in milliseconds, sent in units of 2ms
+const (
+	pulseOnMS  = 100
+	pulseOffMS = 200
+	beepOnMS   = 50
+	beepOffMS  = 150
+	beepCount  = 3
+)
+
+// parseAlert checks an alert named in a form or API request
+func parseAlert(s string) (alertKind, error) {
+	switch a := alertKind(s); a {
+	case alertNone, alertPulse, alertBeep:
+		return a, nil
+	case "none":
+		return alertNone, nil
+	}
+	return alertNone, fmt.Errorf("unknown alert %q", s)
+}
+
+// pulseCommand builds a generate pulse command (ESC p) for connector pin 2
+// or 5 with the on and off times in milliseconds
+func pulseCommand(pin, onMS, offMS int) []byte {
+	m := byte(0)
+	if pin == 5 {
+		m = 1
+	}
+	return []byte{0x1B, 0x70, m, byte(onMS / 2), byte(offMS / 2)}
+}
+
+// sendAlert sends the pulses for an alert.  The caller must hold printMu.
+func sendAlert(a alertKind) error {
+	switch a {
+	case alertPulse:
+		return sendRaw(pulseCommand(conf().Printer.PulsePin, pulseOnMS, pulseOffMS)...)
+	case alertBeep:
+		for i := 0; i < beepCount; i++ {
+			if err := sendRaw(pulseCommand(conf().Printer.PulsePin, beepOnMS, beepOffMS)...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseAlert(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected alertKind
+		wantErr  bool
+	}{
+		{"", alertNone, false},
+		{"none", alertNone, false},
+		{"pulse", alertPulse, false},
+		{"beep", alertBeep, false},
+		{"siren", alertNone, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := parseAlert(tt.input)
+			if (err != nil) != tt.wantErr || result != tt.expected {
+				t.Errorf("parseAlert(%q) = %q, %v, want %q", tt.input, result, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPulseCommand(t *testing.T) {
+	if got, want := pulseCommand(2, 100, 200), []byte{0x1B, 0x70, 0, 50, 100}; !bytes.Equal(got, want) {
+		t.Errorf("pulseCommand(2) = % x, want % x", got, want)
+	}
+	if got, want := pulseCommand(5, 50, 150), []byte{0x1B, 0x70, 1, 25, 75}; !bytes.Equal(got, want) {
+		t.Errorf("pulseCommand(5) = % x, want % x", got, want)
+	}
+}
+
+func TestSendAlert(t *testing.T) {
+	f := useFakePrinter(t)
+	if err := sendAlert(alertNone); err != nil || f.written.Len() != 0 {
+		t.Errorf("sendAlert(none) wrote % x, %v", f.written.Bytes(), err)
+	}
+	if err := sendAlert(alertBeep); err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(f.written.Bytes(), []byte{0x1B, 0x70}); n != beepCount {
+		t.Errorf("sendAlert(beep) sent %d pulses, want %d", n, beepCount)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxKeyLength is the longest idempotency key a client can send
+const maxKeyLength = 200
+
+// apiPrintRequest is the JSON body of POST /api/print.  Either message and
+// barcode give a single label or labels gives a batch.
+type apiPrintRequest struct {
+	Message  string     `json:"message"`
+	Barcode  int        `json:"barcode"`
+	Labels   []apiLabel `json:"labels"`
+	Copies   int        `json:"copies"`
+	Cut      string     `json:"cut"`   // "full", "partial" or "none"
+	CutAt    string     `json:"cutAt"` // "between" or "end"
+	Fit      *bool      `json:"fit"`
+	Alert    string     `json:"alert"`    // "", "pulse" or "beep"
+	Printer  string     `json:"printer"`  // empty for the default
+	Template string     `json:"template"` // empty for the default
+	Urgent   bool       `json:"urgent"`   // jump the queue
+	Key      string     `json:"key"`      // idempotency key, or the Idempotency-Key header
+}
+
+// apiLabel is one label of a batch
+type apiLabel struct {
+	Message string `json:"message"`
+	Barcode int    `json:"barcode"`
+}
+
+// writeJSON sends v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError sends an error message as JSON
+func writeJSONError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+// writeDecodeError reports a request body that is not valid JSON or is too
+// big
+func writeDecodeError(w http.ResponseWriter, err error) {
+	code := http.StatusBadRequest
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		code = http.StatusRequestEntityTooLarge
+	}
+	writeJSONError(w, code, "invalid JSON: "+err.Error())
+}
+
+// writeAPIError sends an error with the status code for its kind: 429 with
+// Retry-After for a rate limit, 403 for something the user's role does not
+// allow, 404 for a job not in the history or queue, 409 for a job already
+// printing and 400 for anything else
+func writeAPIError(w http.ResponseWriter, err error) {
+	var limited *limitError
+	switch {
+	case errors.As(err, &limited):
+		w.Header().Set("Retry-After", strconv.Itoa(limited.retryAfterSeconds()))
+		writeJSONError(w, http.StatusTooManyRequests, err.Error())
+	case isForbidden(err):
+		writeJSONError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, errNoJob), errors.Is(err, errNotWaiting), errors.Is(err, errNoFailedJob):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errJobStarted):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// writeJobResult waits for a job to print like the form does, answering 200
+// when printed, 202 if the job is still queued and 500 if it failed
+func writeJobResult(w http.ResponseWriter, j *job) {
+	if !queue.wait(j, conf().Printer.PrintTimeout.Duration+5*time.Second) {
+		writeJSON(w, http.StatusAccepted, summarizeJob(queue.jobResult(j)))
+		return
+	}
+	result := queue.jobResult(j)
+	code := http.StatusOK
+	if result.State == jobFailed {
+		code = http.StatusInternalServerError
+	}
+	writeJSON(w, code, summarizeJob(result))
+}
+
+// summarizeJob turns a job into its API form
+func summarizeJob(j job) jobSummary {
+	return jobSummary{
+		ID:        j.ID,
+		User:      j.From.User,
+		State:     j.State,
+		Key:       j.Key,
+		Urgent:    j.Options.Urgent,
+		Attempts:  j.Attempts,
+		NextTry:   j.NextTry,
+		Submitted: j.Submitted,
+		Printed:   j.Printed,
+		Finished:  j.Finished,
+		Error:     j.Err,
+	}
+}
+
+// handleAPIPrint queues a label from a script.  It waits for the label to
+// print like the form does, answering 200 when printed, 202 if the job is
+// still queued and 500 if it failed.
+func handleAPIPrint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "POST only")
+		return
+	}
+	var req apiPrintRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	from := requesterFrom(r)
+	if err := conf().checkPrint(from, req.Printer, req.Template); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if req.Key == "" {
+		req.Key = r.Header.Get("Idempotency-Key")
+	}
+	if len(req.Key) > maxKeyLength {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("key is longer than %d characters", maxKeyLength))
+		return
+	}
+	// A job sent again with the same key is answered, not printed twice
+	if j, ok := queue.findKeyed(from, req.Key); ok {
+		writeJobResult(w, j)
+		return
+	}
+	if len(req.Labels) == 0 {
+		req.Labels = []apiLabel{{Message: req.Message, Barcode: req.Barcode}}
+	}
+	labels := make([]labelSpec, 0, len(req.Labels))
+	for _, l := range req.Labels {
+		message, err := cleanMessage(l.Message)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if l.Barcode == 0 {
+			l.Barcode = conf().Defaults.Barcode
+		}
+		if err := checkBarcode(l.Barcode); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		labels = append(labels, labelSpec{Message: message, Barcode: l.Barcode})
+	}
+	fit := conf().Defaults.Fit
+	if req.Fit != nil {
+		fit = *req.Fit
+	}
+	opts, err := parseLabelOptions(len(labels), fit, req.Alert, req.Copies, req.Cut, req.CutAt)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Urgent {
+		if err := checkUrgent(conf(), from); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		opts.Urgent = true
+	}
+
+	if err := limits.admitJob(from, labels, opts, time.Now()); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	j, added := queue.submitKeyed(req.Key, from, labels, opts)
+	if added {
+		auditJob(r, auditPrint, j, "")
+	} else {
+		limits.release(from, jobPaperMM(labels, opts)) // sent twice at once
+	}
+	writeJobResult(w, j)
+}
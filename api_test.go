@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestQueue swaps in a fresh queue printing with print for one test
+func useTestQueue(t *testing.T, print func(*job) (time.Time, error)) *jobQueue {
+	t.Helper()
+	saved := queue
+	queue = newJobQueue()
+	t.Cleanup(func() { queue = saved })
+	go queue.run(print)
+	return queue
+}
+
+func TestAPIPrint(t *testing.T) {
+	useConfigWith(t, func(c *config) { c.Retry.MaxAttempts = 1 })
+	var got []*job
+	useTestQueue(t, func(j *job) (time.Time, error) {
+		got = append(got, j)
+		if j.Labels[0].Message == "jam" {
+			return time.Time{}, errors.New("cutter error")
+		}
+		return time.Now(), nil
+	})
+
+	tests := []struct {
+		name     string
+		body     string
+		code     int
+		expected jobState
+	}{
+		{"Printed", `{"message":"Milk","barcode":7,"alert":"beep","fit":true}`, 200, jobPrinted},
+		{"Failed", `{"message":"jam"}`, 500, jobFailed},
+		{"Batch", `{"labels":[{"message":"Tag","barcode":100},{"message":"Tag"}],"copies":2,"cut":"partial","cutAt":"end"}`, 200, jobPrinted},
+		{"Too many copies", `{"message":"Milk","copies":99}`, 400, ""},
+		{"Unknown cut", `{"message":"Milk","cut":"half"}`, 400, ""},
+		{"Bad JSON", `{"message":`, 400, ""},
+		{"Empty message", `{"message":"  "}`, 400, ""},
+		{"Unknown alert", `{"message":"Milk","alert":"siren"}`, 400, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleAPIPrint(w, httptest.NewRequest("POST", "/api/print", strings.NewReader(tt.body)))
+			if w.Code != tt.code {
+				t.Fatalf("POST /api/print = %d %s, want %d", w.Code, w.Body.String(), tt.code)
+			}
+			if tt.expected == "" {
+				return
+			}
+			var j jobSummary
+			if err := json.Unmarshal(w.Body.Bytes(), &j); err != nil {
+				t.Fatal(err)
+			}
+			if j.State != tt.expected || j.ID == 0 {
+				t.Errorf("POST /api/print job = %+v, want %s", j, tt.expected)
+			}
+		})
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("printed %d jobs, want 3", len(got))
+	}
+	want := labelOptions{Fit: true, Alert: alertBeep, Copies: 1, Cut: cutFull, CutAt: cutBetween}
+	if got[0].Options != want {
+		t.Errorf("printed options = %+v, want %+v", got[0].Options, want)
+	}
+	batch := got[2]
+	want = labelOptions{Copies: 2, Cut: cutPartial, CutAt: cutAtEnd}
+	if batch.Options != want || len(batch.Labels) != 2 || batch.Labels[1] != (labelSpec{"Tag", 5}) {
+		t.Errorf("batch job = %+v, want two labels with %+v", batch, want)
+	}
+}
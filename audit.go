@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit log actions
+const (
+	auditPrint        = "print"
+	auditReprint      = "reprint"
+	auditCancel       = "cancel"
+	auditUrgent       = "urgent"
+	auditResubmit     = "resubmit"
+	auditDismiss      = "dismiss"
+	auditPause        = "pause"
+	auditResume       = "resume"
+	auditSchedule     = "schedule"
+	auditConfigChange = "config"
+	auditPaperRoll    = "paper_roll"
+	auditLogin        = "login"
+	auditLoginFailed  = "login_failed"
+	auditLogout       = "logout"
+)
+
+// auditActions are the actions the audit page can filter on
+var auditActions = []string{auditPrint, auditReprint, auditCancel, auditUrgent, auditResubmit, auditDismiss, auditPause, auditResume, auditSchedule, auditConfigChange, auditPaperRoll, auditLogin, auditLoginFailed, auditLogout}
+
+// auditShown is the most events the audit page lists
+const auditShown = 200
+
+// auditEvent is one line of the audit log
+type auditEvent struct {
+	Time      time.Time    `json:"time"`
+	Action    string       `json:"action"`
+	User      string       `json:"user,omitempty"`
+	Token     bool         `json:"token,omitempty"` // User is an API token
+	IP        string       `json:"ip,omitempty"`
+	UserAgent string       `json:"userAgent,omitempty"`
+	Job       int          `json:"job,omitempty"`
+	Labels    []auditLabel `json:"labels,omitempty"`
+	Copies    int          `json:"copies,omitempty"`
+	Detail    string       `json:"detail,omitempty"`
+}
+
+// auditLabel is a label as it was laid out to print
+type auditLabel struct {
+	Barcode int    `json:"barcode"`
+	Text    string `json:"text"`
+}
+
+// auditLog appends events as JSON lines to the file the config names,
+// starting a new file when it gets too big
+type auditLog struct {
+	mu sync.Mutex
+}
+
+// audit is the audit log
+var audit = &auditLog{}
+
+// path returns the audit log file, empty when the log is off
+func (a auditConfig) path(stateDir string) string {
+	if a.File != "" || stateDir == "" {
+		return a.File
+	}
+	return filepath.Join(stateDir, "audit.jsonl")
+}
+
+// newAuditEvent starts an event for an action by whoever sent r
+func newAuditEvent(r *http.Request, action string) auditEvent {
+	from := requesterFrom(r)
+	return auditEvent{
+		Time:      time.Now(),
+		Action:    action,
+		User:      from.User,
+		Token:     from.Token,
+		IP:        from.IP,
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// auditJob records a job being submitted or changed by whoever sent r
+func auditJob(r *http.Request, action string, j *job, detail string) {
+	e := newAuditEvent(r, action)
+	e.Detail = detail
+	recordJob(e, j)
+}
+
+// recordJob adds a job and its labels to an event and records it
+func recordJob(e auditEvent, j *job) {
+	e.Job = j.ID
+	e.Labels = renderLabels(conf(), j.Labels, j.Options)
+	e.Copies = j.Options.copies()
+	audit.record(e)
+}
+
+// renderLabels lays out labels the way they print, heading and all
+func renderLabels(c *config, labels []labelSpec, opts labelOptions) []auditLabel {
+	out := make([]auditLabel, len(labels))
+	for i, l := range labels {
+		var lines []string
+		if c.Template.Heading != "" {
+			lines = append(lines, c.Template.Heading)
+		}
+		_, pages, err := c.media.layoutLabels(strings.TrimSpace(stripControl(l.Message)), opts.Fit)
+		if err != nil {
+			lines = append(lines, l.Message)
+		}
+		for _, page := range pages {
+			lines = append(lines, page...)
+		}
+		out[i] = auditLabel{Barcode: l.Barcode, Text: strings.Join(lines, "\n")}
+	}
+	return out
+}
+
+// record appends an event to the log.  Errors are reported but do not stop
+// the action being logged.
+func (a *auditLog) record(e auditEvent) {
+	c := conf()
+	path := c.Audit.path(c.Server.StateDir)
+	if path == "" {
+		return
+	}
+	if err := a.write(path, int64(c.Audit.MaxSize)<<20, c.Audit.Keep, e); err != nil {
+		fmt.Println("Error writing audit log:", err)
+	}
+}
+
+// write appends e to the log at path, rotating it first if the line would
+// take it over maxBytes
+func (a *auditLog) write(path string, maxBytes int64, keep int, e auditEvent) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > maxBytes {
+		if err := rotate(path, keep); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate moves path to path.1, path.1 to path.2 and so on, dropping the
+// file beyond keep
+func rotate(path string, keep int) error {
+	os.Remove(fmt.Sprintf("%s.%d", path, keep))
+	for n := keep - 1; n >= 1; n-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", path, n), fmt.Sprintf("%s.%d", path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
+
+// auditFilter picks events for the audit page.  Empty fields match
+// everything.
+type auditFilter struct {
+	User   string
+	Action string
+	Text   string // in the labels, detail, IP or user agent
+	Since  time.Time
+	Until  time.Time
+}
+
+// matches reports whether e passes the filter
+func (f auditFilter) matches(e auditEvent) bool {
+	switch {
+	case f.User != "" && !strings.EqualFold(e.User, f.User):
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	if f.Text == "" {
+		return true
+	}
+	text := strings.ToLower(f.Text)
+	fields := []string{e.Detail, e.IP, e.UserAgent}
+	for _, l := range e.Labels {
+		fields = append(fields, l.Text)
+	}
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// read returns up to n events matching f from the log at path and the
+// files rotated out of it, newest first
+func (a *auditLog) read(path string, keep int, f auditFilter, n int) ([]auditEvent, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var events []auditEvent
+	for i := 0; i <= keep && len(events) < n; i++ {
+		name := path
+		if i > 0 {
+			name = fmt.Sprintf("%s.%d", path, i)
+		}
+		fileEvents, err := readAuditFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return events, err
+		}
+		for j := len(fileEvents) - 1; j >= 0 && len(events) < n; j-- {
+			if f.matches(fileEvents[j]) {
+				events = append(events, fileEvents[j])
+			}
+		}
+	}
+	return events, nil
+}
+
+// readAuditFile reads the events in one log file, oldest first, skipping
+// lines that are not events
+func readAuditFile(name string) ([]auditEvent, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var events []auditEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	for scanner.Scan() {
+		var e auditEvent
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// AuditData is passed to the audit page
+type AuditData struct {
+	User    string // the filter as given
+	Action  string
+	Text    string
+	Since   string
+	Until   string
+	Actions []string
+	Events  []auditEvent
+	Enabled bool
+	Error   string
+}
+
+// handleAudit shows the newest audit events, filtered by the query
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	c := conf()
+	q := r.URL.Query()
+	data := AuditData{
+		User:    q.Get("user"),
+		Action:  q.Get("action"),
+		Text:    q.Get("text"),
+		Since:   q.Get("since"),
+		Until:   q.Get("until"),
+		Actions: auditActions,
+	}
+	f := auditFilter{User: data.User, Action: data.Action, Text: data.Text}
+	if data.Since != "" {
+		since, err := time.ParseInLocation("2006-01-02", data.Since, time.Local)
+		if err != nil {
+			data.Error = "Since must be a date"
+		}
+		f.Since = since
+	}
+	if data.Until != "" {
+		until, err := time.ParseInLocation("2006-01-02", data.Until, time.Local)
+		if err != nil {
+			data.Error = "Until must be a date"
+		}
+		f.Until = until.AddDate(0, 0, 1) // to the end of the day
+	}
+
+	path := c.Audit.path(c.Server.StateDir)
+	data.Enabled = path != ""
+	if data.Enabled && data.Error == "" {
+		var err error
+		if data.Events, err = audit.read(path, c.Audit.Keep, f, auditShown); err != nil {
+			data.Error = "Error reading the audit log: " + err.Error()
+		}
+	}
+	if err := tmpl.ExecuteTemplate(w, "audit.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useAudit sends the audit log to a new file for one test
+func useAudit(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	useConfigWith(t, func(c *config) { c.Audit.File = path })
+	return path
+}
+
+func TestAuditRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a := &auditLog{}
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := range 20 {
+		e := auditEvent{Time: start.Add(time.Duration(i) * time.Minute), Action: auditPrint, User: "alice", Job: i + 1}
+		if err := a.write(path, 300, 2, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if info, err := os.Stat(name); err != nil || info.Size() > 300 {
+			t.Errorf("%s: %v, want a file of at most 300 bytes", name, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 kept, want only 2 old files", path)
+	}
+
+	events, err := a.read(path, 2, auditFilter{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || events[0].Job != 20 {
+		t.Fatalf("read() = %d events starting %+v, want the newest first", len(events), events)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Job != events[i-1].Job-1 {
+			t.Fatalf("read() jobs %d then %d, want them in order across files", events[i-1].Job, events[i].Job)
+		}
+	}
+	if events, _ := a.read(path, 2, auditFilter{}, 3); len(events) != 3 {
+		t.Errorf("read() with a limit of 3 = %d events", len(events))
+	}
+}
+
+func TestAuditFilter(t *testing.T) {
+	e := auditEvent{
+		Time:      time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC),
+		Action:    auditPrint,
+		User:      "alice",
+		IP:        "192.0.2.1",
+		UserAgent: "curl/8.0",
+		Labels:    []auditLabel{{Barcode: 5, Text: "Task\nWater the plants"}},
+	}
+	tests := []struct {
+		name     string
+		filter   auditFilter
+		expected bool
+	}{
+		{"Everything", auditFilter{}, true},
+		{"User", auditFilter{User: "Alice"}, true},
+		{"Other user", auditFilter{User: "bob"}, false},
+		{"Action", auditFilter{Action: auditPrint}, true},
+		{"Other action", auditFilter{Action: auditLogin}, false},
+		{"Label text", auditFilter{Text: "plants"}, true},
+		{"User agent", auditFilter{Text: "curl"}, true},
+		{"Missing text", auditFilter{Text: "printer"}, false},
+		{"Since", auditFilter{Since: e.Time.Add(-time.Hour)}, true},
+		{"Too late", auditFilter{Since: e.Time.Add(time.Hour)}, false},
+		{"Until", auditFilter{Until: e.Time}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.matches(e); got != tt.expected {
+				t.Errorf("matches() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAuditPrintAndPage(t *testing.T) {
+	path := useAudit(t)
+	useTestQueue(t, func(j *job) (time.Time, error) { return time.Now(), nil })
+	tmpl = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+	r := httptest.NewRequest("POST", "/api/print", strings.NewReader(`{"message":"Water the plants","barcode":12,"copies":2}`))
+	r.Header.Set("User-Agent", "curl/8.0")
+	handleAPIPrint(httptest.NewRecorder(), withUser(r, "alice"))
+
+	events, err := audit.read(path, 1, auditFilter{}, 10)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("audit log = %+v, %v, want one event", events, err)
+	}
+	e := events[0]
+	want := "Task\nWater the plants"
+	if e.Action != auditPrint || e.User != "alice" || e.UserAgent != "curl/8.0" || e.Job == 0 ||
+		e.Copies != 2 || len(e.Labels) != 1 || e.Labels[0] != (auditLabel{12, want}) {
+		t.Errorf("audit event = %+v, want alice printing %q", e, want)
+	}
+
+	w := httptest.NewRecorder()
+	handleAudit(w, httptest.NewRequest("GET", "/audit?user=alice&text=plants", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "Water the plants") {
+		t.Errorf("audit page = %d, want the print listed", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handleAudit(w, httptest.NewRequest("GET", fmt.Sprintf("/audit?action=%s", auditLogin), nil))
+	if strings.Contains(w.Body.String(), "Water the plants") {
+		t.Errorf("audit page filtered on logins lists the print")
+	}
+}
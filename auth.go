@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authMode is how people using the web page prove who they are
+type authMode string
+
+const (
+	authNone     authMode = "none"     // anyone on the network can print
+	authPassword authMode = "password" // one shared password
+	authUsers    authMode = "users"    // accounts in the users file
+)
+
+// sessionCookie holds the session of a logged in user
+const sessionCookie = "golabel_session"
+
+// sharedUser is recorded on jobs printed with the shared password when no
+// name is given
+const sharedUser = "web"
+
+// authenticator checks a name and password from the login page, returning
+// the user to record on jobs
+type authenticator interface {
+	login(name, password string) (string, bool)
+}
+
+// sharedPassword lets in anyone who knows the password, under the name they
+// give
+type sharedPassword struct {
+	hash []byte
+}
+
+func (a sharedPassword) login(name, password string) (string, bool) {
+	if bcrypt.CompareHashAndPassword(a.hash, []byte(password)) != nil {
+		return "", false
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = sharedUser
+	}
+	return name, true
+}
+
+// userFile holds accounts read from an htpasswd style file of name:hash
+// lines, with bcrypt hashes as made by htpasswd -B
+type userFile map[string][]byte
+
+func (a userFile) login(name, password string) (string, bool) {
+	hash, ok := a[name]
+	if !ok {
+		// take as long as a real check so names cannot be guessed
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", false
+	}
+	return name, bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// dummyHash is checked against for unknown users
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("golabel"), bcrypt.DefaultCost)
+
+// loadUserFile reads the users file at path
+func loadUserFile(path string) (userFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := userFile{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s line %d: want name:hash", path, n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s line %d: %s has no bcrypt hash", path, n, name)
+		}
+		users[name] = []byte(hash)
+	}
+	return users, scanner.Err()
+}
+
+// newAuthenticator builds the authenticator for the security settings
+func newAuthenticator(s securityConfig) (authenticator, error) {
+	switch authMode(s.Auth) {
+	case authNone:
+		return nil, nil
+	case authPassword:
+		if _, err := bcrypt.Cost([]byte(s.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("security.password_hash must be a bcrypt hash")
+		}
+		return sharedPassword{hash: []byte(s.PasswordHash)}, nil
+	case authUsers:
+		if s.UsersFile == "" {
+			return nil, fmt.Errorf("security.users_file is needed for users auth")
+		}
+		users, err := loadUserFile(s.UsersFile)
+		if err != nil {
+			return nil, fmt.Errorf("security.users_file: %w", err)
+		}
+		return users, nil
+	}
+	return nil, fmt.Errorf("security.auth must be none, password or users, not %q", s.Auth)
+}
+
+// hashToken returns the hex SHA-256 of an API token, as kept in the config
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenUser returns the name of the API token, if it is one
+func (s securityConfig) tokenUser(token string) (string, bool) {
+	hash := []byte(hashToken(token))
+	for name, want := range s.APITokens {
+		if subtle.ConstantTimeCompare(hash, []byte(strings.ToLower(want))) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// session is a logged in user
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// sessionStore holds the sessions of logged in users.  Sessions are kept in
+// memory so everyone logs in again after a restart.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+// sessions are the logged in users
+var sessions = &sessionStore{sessions: map[string]session{}}
+
+// start begins a session for user, returning its id
+func (s *sessionStore) start(user string, lifetime time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, v := range s.sessions {
+		if now.After(v.expires) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[id] = session{user: user, expires: now.Add(lifetime)}
+	return id, nil
+}
+
+// user returns the user of a session that has not expired
+func (s *sessionStore) user(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.sessions[id]
+	if !ok || time.Now().After(v.expires) {
+		return "", false
+	}
+	return v.user, true
+}
+
+// end logs a session out
+func (s *sessionStore) end(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// requester is who sent a request
+type requester struct {
+	User  string // empty when auth is off
+	Token bool   // User is the name of an API token
+	IP    string
+}
+
+// owns reports whether from sent j.  An API token does not own the jobs of
+// a user of the same name, nor the other way round.
+func (from requester) owns(j *job) bool {
+	return j.From.User == from.User && j.From.Token == from.Token
+}
+
+// requesterKey is the request context key for the authenticated requester
+type requesterKey struct{}
+
+// requesterFrom returns who sent a request
+func requesterFrom(r *http.Request) requester {
+	from, ok := r.Context().Value(requesterKey{}).(requester)
+	if !ok {
+		from.IP = remoteIP(r)
+	}
+	return from
+}
+
+// userFrom returns the user a request was authenticated as, empty when
+// authentication is off
+func userFrom(r *http.Request) string {
+	return requesterFrom(r).User
+}
+
+// remoteIP returns the address a request came from, without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// authenticate works out who sent a request, from an API token or a session
+// cookie
+func authenticate(r *http.Request) (requester, bool) {
+	from := requester{IP: remoteIP(r)}
+	security := conf().Security
+	if authMode(security.Auth) == authNone {
+		return from, true
+	}
+	var ok bool
+	if token, isToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); isToken {
+		from.User, ok = security.tokenUser(strings.TrimSpace(token))
+		from.Token = true
+	} else if cookie, err := r.Cookie(sessionCookie); err == nil {
+		from.User, ok = sessions.user(cookie.Value)
+	}
+	return from, ok
+}
+
+// requireLogin serves a page only to a logged in user, sending anyone else
+// to the login page
+func requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, ok := authenticate(r)
+		if !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), requesterKey{}, from)))
+	}
+}
+
+// requireAPIUser serves an API request only with a valid token or session
+func requireAPIUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, ok := authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="golabel"`)
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), requesterKey{}, from)))
+	}
+}
+
+// LoginData is passed to the login page
+type LoginData struct {
+	Users bool // ask for a user name rather than an optional name
+	Error string
+	CSRF  string
+}
+
+// handleLogin shows the login page and starts a session when the password
+// is right
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	c := conf()
+	if authMode(c.Security.Auth) == authNone {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	data := LoginData{Users: authMode(c.Security.Auth) == authUsers}
+	code := http.StatusOK
+	if r.Method == http.MethodPost {
+		user, ok := c.auth.login(r.FormValue("name"), r.FormValue("password"))
+		e := newAuditEvent(r, auditLogin)
+		e.User = user
+		if !ok {
+			e.Action = auditLoginFailed
+			e.User = r.FormValue("name")
+		}
+		audit.record(e)
+		if ok {
+			id, err := sessions.start(user, c.Security.SessionLifetime.Duration)
+			if err == nil {
+				http.SetCookie(w, &http.Cookie{
+					Name:     sessionCookie,
+					Value:    id,
+					Path:     "/",
+					MaxAge:   int(c.Security.SessionLifetime.Seconds()),
+					HttpOnly: true,
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteLaxMode,
+				})
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+			data.Error = "Could not start a session: " + err.Error()
+		} else {
+			data.Error = "Wrong name or password"
+		}
+		code = http.StatusUnauthorized
+	}
+	data.CSRF = csrfToken(w, r)
+	w.WriteHeader(code)
+	if err := tmpl.ExecuteTemplate(w, "login.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleLogout ends the session
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		e := newAuditEvent(r, auditLogout)
+		e.User, _ = sessions.user(cookie.Value)
+		audit.record(e)
+		sessions.end(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHash makes a cheap bcrypt hash for a test password
+func testHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestLoadUserFile(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "users")
+	os.WriteFile(good, []byte("# accounts\nalice:"+testHash(t, "secret")+"\n\nbob:"+testHash(t, "hunter2")+"\n"), 0o600)
+	users, err := loadUserFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, password string
+		ok             bool
+	}{
+		{"alice", "secret", true},
+		{"bob", "hunter2", true},
+		{"alice", "hunter2", false},
+		{"carol", "secret", false},
+	}
+	for _, tt := range tests {
+		if user, ok := users.login(tt.name, tt.password); ok != tt.ok || (ok && user != tt.name) {
+			t.Errorf("login(%q, %q) = %q, %v, want %v", tt.name, tt.password, user, ok, tt.ok)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad")
+	os.WriteFile(bad, []byte("alice:plaintext\n"), 0o600)
+	if _, err := loadUserFile(bad); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("loadUserFile() with a plain password error = %v, want line 1", err)
+	}
+}
+
+func TestSharedPassword(t *testing.T) {
+	a := sharedPassword{hash: []byte(testHash(t, "labels"))}
+	if user, ok := a.login("", "labels"); !ok || user != sharedUser {
+		t.Errorf("login without a name = %q, %v, want %q", user, ok, sharedUser)
+	}
+	if user, ok := a.login(" Sam ", "labels"); !ok || user != "Sam" {
+		t.Errorf("login as Sam = %q, %v", user, ok)
+	}
+	if _, ok := a.login("Sam", "wrong"); ok {
+		t.Error("login with the wrong password succeeded")
+	}
+}
+
+func TestRequesterOwns(t *testing.T) {
+	j := &job{From: requester{User: "ci", IP: "192.0.2.1"}}
+	tests := []struct {
+		from     requester
+		expected bool
+	}{
+		{requester{User: "ci", IP: "192.0.2.9"}, true},
+		{requester{User: "ci", Token: true}, false},
+		{requester{User: "bob"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.owns(j); got != tt.expected {
+			t.Errorf("%+v owns a job of user ci = %v, want %v", tt.from, got, tt.expected)
+		}
+	}
+}
+
+func TestRequireLogin(t *testing.T) {
+	useConfigWith(t, func(c *config) {
+		c.Security.Auth = string(authPassword)
+		c.Security.PasswordHash = testHash(t, "labels")
+		c.Security.APITokens = map[string]string{"inventory": hashToken("tok-123")}
+	})
+	var gotUser string
+	page := requireLogin(func(w http.ResponseWriter, r *http.Request) { gotUser = userFrom(r) })
+	api := requireAPIUser(func(w http.ResponseWriter, r *http.Request) { gotUser = userFrom(r) })
+
+	// not logged in
+	w := httptest.NewRecorder()
+	page(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Errorf("page without login = %d to %q, want redirect to /login", w.Code, w.Header().Get("Location"))
+	}
+	w = httptest.NewRecorder()
+	api(w, httptest.NewRequest("POST", "/api/print", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("API without a token = %d, want 401", w.Code)
+	}
+
+	// API token
+	r := httptest.NewRequest("POST", "/api/print", nil)
+	r.Header.Set("Authorization", "Bearer tok-123")
+	w = httptest.NewRecorder()
+	api(w, r)
+	if w.Code != http.StatusOK || gotUser != "inventory" {
+		t.Errorf("API with a token = %d as %q, want inventory", w.Code, gotUser)
+	}
+	r.Header.Set("Authorization", "Bearer tok-456")
+	w = httptest.NewRecorder()
+	api(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("API with a wrong token = %d, want 401", w.Code)
+	}
+
+	// log in through the form and use the session cookie
+	tmpl = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+	form := url.Values{"name": {"Sam"}, "password": {"labels"}}
+	r = httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handleLogin(w, r)
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusSeeOther || len(cookies) != 1 || cookies[0].Name != sessionCookie {
+		t.Fatalf("login = %d with cookies %v, want a session", w.Code, cookies)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	page(w, r)
+	if w.Code != http.StatusOK || gotUser != "Sam" {
+		t.Errorf("page with session = %d as %q, want Sam", w.Code, gotUser)
+	}
+
+	// wrong password
+	form.Set("password", "wrong")
+	r = httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handleLogin(w, r)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Wrong name or password") {
+		t.Errorf("login with wrong password = %d", w.Code)
+	}
+}
+
+func TestAuthConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"Unknown mode", "[security]\nauth = \"ldap\"", "security.auth"},
+		{"Plain password", "[security]\nauth = \"password\"\npassword_hash = \"labels\"", "password_hash"},
+		{"No users file", "[security]\nauth = \"users\"", "users_file"},
+		{"Missing users file", "[security]\nauth = \"users\"\nusers_file = \"/nonexistent/users\"", "users_file"},
+		{"Plain token", "[security.api_tokens]\nscript = \"tok-123\"", "api_tokens.script"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, tt.text))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("loadConfig() error = %v, want it to mention %q", err, tt.expected)
+			}
+		})
+	}
+}
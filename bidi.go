@@ -0,0 +1,254 @@
+package main
+
+import (
+	"golang.org/x/text/unicode/bidi"
+)
+
+// This is a cut down Unicode bidirectional algorithm (UAX #9) used in the
+// layout stage so that Hebrew and Arabic text, and lines mixing them with
+// left to right text, print in visual order.  Explicit embeddings and
+// isolates are not supported and are treated as boundary neutrals.  The
+// paragraph level is found once for each line of the message and lines are
+// reordered at that level after wrapping, so each printed line reads
+// correctly.
+
+// bidiClasses looks up the bidi class of each rune, folding the explicit
+// formatting characters into BN
+func bidiClasses(runes []rune) []bidi.Class {
+	classes := make([]bidi.Class, len(runes))
+	for i, r := range runes {
+		props, _ := bidi.LookupRune(r)
+		c := props.Class()
+		switch c {
+		case bidi.LRE, bidi.LRO, bidi.RLE, bidi.RLO, bidi.PDF,
+			bidi.LRI, bidi.RLI, bidi.FSI, bidi.PDI, bidi.Control:
+			c = bidi.BN
+		}
+		classes[i] = c
+	}
+	return classes
+}
+
+// paragraphLevel finds the base level from the first strong character, 0
+// for left to right and 1 for right to left (rules P2 and P3)
+func paragraphLevel(classes []bidi.Class) int {
+	for _, c := range classes {
+		switch c {
+		case bidi.L:
+			return 0
+		case bidi.R, bidi.AL:
+			return 1
+		}
+	}
+	return 0
+}
+
+// textLevel returns the paragraph level of a line of text
+func textLevel(s string) int {
+	return paragraphLevel(bidiClasses([]rune(s)))
+}
+
+// isNeutral reports whether a resolved class is a neutral for rules N1/N2
+func isNeutral(c bidi.Class) bool {
+	switch c {
+	case bidi.B, bidi.S, bidi.WS, bidi.ON, bidi.BN:
+		return true
+	}
+	return false
+}
+
+// resolveLevels resolves the embedding level of every rune in a line
+func resolveLevels(classes []bidi.Class, base int) []int {
+	n := len(classes)
+	t := make([]bidi.Class, n)
+	copy(t, classes)
+
+	sos := bidi.L
+	if base%2 == 1 {
+		sos = bidi.R
+	}
+
+	// W1: non spacing marks take the class of the previous character
+	prev := sos
+	for i := range t {
+		if t[i] == bidi.NSM {
+			t[i] = prev
+		}
+		if t[i] != bidi.BN {
+			prev = t[i]
+		}
+	}
+
+	// W2 and W3: European numbers after Arabic letters become Arabic
+	// numbers, then Arabic letters become R
+	strong := sos
+	for i := range t {
+		switch t[i] {
+		case bidi.L, bidi.R, bidi.AL:
+			strong = t[i]
+		case bidi.EN:
+			if strong == bidi.AL {
+				t[i] = bidi.AN
+			}
+		}
+	}
+	for i := range t {
+		if t[i] == bidi.AL {
+			t[i] = bidi.R
+		}
+	}
+
+	// W4: a single separator between two numbers of the same type joins them
+	for i := 1; i < n-1; i++ {
+		switch {
+		case t[i] == bidi.ES && t[i-1] == bidi.EN && t[i+1] == bidi.EN:
+			t[i] = bidi.EN
+		case t[i] == bidi.CS && t[i-1] == t[i+1] && (t[i-1] == bidi.EN || t[i-1] == bidi.AN):
+			t[i] = t[i-1]
+		}
+	}
+
+	// W5: terminators next to European numbers become European numbers
+	for i := 0; i < n; i++ {
+		if t[i] != bidi.ET {
+			continue
+		}
+		end := i
+		for end < n && t[end] == bidi.ET {
+			end++
+		}
+		if (i > 0 && t[i-1] == bidi.EN) || (end < n && t[end] == bidi.EN) {
+			for j := i; j < end; j++ {
+				t[j] = bidi.EN
+			}
+		}
+		i = end - 1
+	}
+
+	// W6 and W7: remaining separators are neutral and European numbers in
+	// a left to right context are L
+	strong = sos
+	for i := range t {
+		switch t[i] {
+		case bidi.ES, bidi.ET, bidi.CS:
+			t[i] = bidi.ON
+		case bidi.L, bidi.R:
+			strong = t[i]
+		case bidi.EN:
+			if strong == bidi.L {
+				t[i] = bidi.L
+			}
+		}
+	}
+
+	// N1 and N2: neutrals between characters of the same direction take
+	// that direction, otherwise the embedding direction
+	direction := func(c bidi.Class) bidi.Class {
+		if c == bidi.L {
+			return bidi.L
+		}
+		return bidi.R // R, EN and AN all count as R
+	}
+	for i := 0; i < n; i++ {
+		if !isNeutral(t[i]) {
+			continue
+		}
+		end := i
+		for end < n && isNeutral(t[end]) {
+			end++
+		}
+		before, after := sos, sos
+		if i > 0 {
+			before = direction(t[i-1])
+		}
+		if end < n {
+			after = direction(t[end])
+		}
+		resolved := sos
+		if before == after {
+			resolved = before
+		}
+		for j := i; j < end; j++ {
+			t[j] = resolved
+		}
+		i = end - 1
+	}
+
+	// I1 and I2: implicit levels
+	levels := make([]int, n)
+	for i, c := range t {
+		levels[i] = base
+		if base%2 == 0 {
+			switch c {
+			case bidi.R:
+				levels[i]++
+			case bidi.AN, bidi.EN:
+				levels[i] += 2
+			}
+		} else if c == bidi.L || c == bidi.EN || c == bidi.AN {
+			levels[i]++
+		}
+	}
+
+	// L1: trailing whitespace goes back to the paragraph level
+	for i := n - 1; i >= 0; i-- {
+		if classes[i] != bidi.WS && classes[i] != bidi.BN && classes[i] != bidi.S {
+			break
+		}
+		levels[i] = base
+	}
+	return levels
+}
+
+// visualOrder reorders a single printed line of a paragraph at level base
+// from logical to visual order (rule L2) and mirrors brackets in right
+// to left runs (rule L4).  Left to right lines with no right to left
+// characters are returned unchanged.
+func visualOrder(line string, base int) string {
+	runes := []rune(line)
+	classes := bidiClasses(runes)
+	hasRTL := false
+	for _, c := range classes {
+		if c == bidi.R || c == bidi.AL || c == bidi.AN {
+			hasRTL = true
+			break
+		}
+	}
+	if !hasRTL && base == 0 {
+		return line
+	}
+
+	levels := resolveLevels(classes, base)
+	highest, lowestOdd := 0, 0
+	for _, l := range levels {
+		highest = max(highest, l)
+		if l%2 == 1 && (lowestOdd == 0 || l < lowestOdd) {
+			lowestOdd = l
+		}
+	}
+
+	for i, r := range runes {
+		if levels[i]%2 == 1 {
+			runes[i] = []rune(bidi.ReverseString(string(r)))[0]
+		}
+	}
+
+	// Reverse every run at or above each level, from the highest down
+	for level := highest; level >= lowestOdd && level > 0; level-- {
+		for i := 0; i < len(runes); i++ {
+			if levels[i] < level {
+				continue
+			}
+			end := i
+			for end < len(runes) && levels[end] >= level {
+				end++
+			}
+			for a, b := i, end-1; a < b; a, b = a+1, b-1 {
+				runes[a], runes[b] = runes[b], runes[a]
+				levels[a], levels[b] = levels[b], levels[a]
+			}
+			i = end - 1
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVisualOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected string
+	}{
+		{"Left to right unchanged", "Hello world", "Hello world"},
+		{"Empty", "", ""},
+		{"Hebrew word", "שלום", "םולש"},
+		{"Hebrew in English", "hello שלום world", "hello םולש world"},
+		{"Two Hebrew words", "שלום עולם", "םלוע םולש"},
+		{"Number in right to left line", "שלום 123", "123 םולש"},
+		{"Brackets are mirrored", "(שלום)", "(םולש)"},
+		{"English in Hebrew", "שלום abc עולם", "םלוע abc םולש"},
+		{"Arabic letters", "مرحبا", "ابحرم"},
+		{"Trailing space stays at end", "abc שלום ", "abc םולש "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := visualOrder(tt.line, textLevel(tt.line))
+			if result != tt.expected {
+				t.Errorf("visualOrder(%q) = %q, want %q", tt.line, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParagraphLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected int
+	}{
+		{"Latin", "abc", 0},
+		{"Hebrew", "שלום", 1},
+		{"Leading digits then Hebrew", "12 שלום", 1},
+		{"No strong characters", "123 !", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := paragraphLevel(bidiClasses([]rune(tt.line)))
+			if result != tt.expected {
+				t.Errorf("paragraphLevel(%q) = %d, want %d", tt.line, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// The printer library prints everything in ISO-8859-15, which has no Hebrew,
+// Arabic or CJK.  Lines with such text are sent by golabel itself, switching
+// the printer to another code page with ESC t for each run of characters the
+// current one lacks, or for wide characters to the Kanji mode (FS &) of a
+// printer that has one, and back again at the end of the line.  Lines are
+// in visual order by then, so each run is sent as it stands.
+
+// codePage is a character table the printer can switch to
+type codePage struct {
+	number byte // n in ESC t n
+	table  *charmap.Charmap
+}
+
+// latinCodePage is the table the printer library selects in Init and
+// prints with
+var latinCodePage = codePage{40, charmap.ISO8859_15}
+
+// codePages are the tables a character is looked for in, in order
+var codePages = []codePage{
+	latinCodePage,
+	{49, charmap.Windows1255}, // WPC1255, Hebrew
+	{50, charmap.Windows1256}, // WPC1256, Arabic
+}
+
+// kanjiCharset is the double byte character set of a printer with a Kanji
+// mode, as set in printer.kanji
+type kanjiCharset struct {
+	encoding encoding.Encoding
+	setup    []byte // sent before FS & to choose the character set, if needed
+}
+
+// kanjiCharsets are the character sets printer.kanji can name
+var kanjiCharsets = map[string]*kanjiCharset{
+	"shift_jis": {japanese.ShiftJIS, []byte{0x1C, 'C', 1}}, // FS C 1
+	"gb18030":   {simplifiedchinese.GB18030, nil},
+	"big5":      {traditionalchinese.Big5, nil},
+	"euc_kr":    {korean.EUCKR, nil},
+}
+
+// encodeWide encodes a wide character in the Kanji character set, if there
+// is one and it has the character
+func (k *kanjiCharset) encodeWide(r rune) ([]byte, bool) {
+	if k == nil || runeWidth(r) != 2 {
+		return nil, false
+	}
+	b, err := k.encoding.NewEncoder().Bytes([]byte(string(r)))
+	return b, err == nil
+}
+
+// printable reports whether a code page or the Kanji character set has r
+func (k *kanjiCharset) printable(r rune) bool {
+	if _, ok := k.encodeWide(r); ok {
+		return true
+	}
+	_, ok := codePageOf(r)
+	return ok
+}
+
+// codePageOf returns the first code page with r
+func codePageOf(r rune) (codePage, bool) {
+	for _, cp := range codePages {
+		if _, ok := cp.table.EncodeRune(r); ok {
+			return cp, true
+		}
+	}
+	return codePage{}, false
+}
+
+// encodeLine encodes a line for the printer, starting and ending in the
+// Latin code page and switching code page only where the current one does
+// not have a character.  Wide characters use the Kanji mode of kanji, if
+// not nil.
+func encodeLine(line string, kanji *kanjiCharset) ([]byte, error) {
+	var out []byte
+	current := latinCodePage
+	inKanji := false
+	for _, r := range line {
+		if wide, ok := kanji.encodeWide(r); ok {
+			if !inKanji {
+				out = append(append(out, kanji.setup...), 0x1C, '&')
+				inKanji = true
+			}
+			out = append(out, wide...)
+			continue
+		}
+		if inKanji {
+			out = append(out, 0x1C, '.')
+			inKanji = false
+		}
+		b, ok := current.table.EncodeRune(r)
+		if !ok {
+			cp, found := codePageOf(r)
+			if !found {
+				return nil, fmt.Errorf("no code page has %q", r)
+			}
+			current = cp
+			out = append(out, 0x1B, 't', cp.number)
+			b, _ = cp.table.EncodeRune(r)
+		}
+		out = append(out, b)
+	}
+	if inKanji {
+		out = append(out, 0x1C, '.')
+	}
+	if current != latinCodePage {
+		out = append(out, 0x1B, 't', latinCodePage.number)
+	}
+	return out, nil
+}
+
+// printLine prints one line of text, through the printer library when it is
+// all Latin and with code page switches otherwise.  The caller must hold
+// printMu.
+func printLine(c *config, line string) error {
+	b, err := encodeLine(line, c.kanji)
+	if err != nil {
+		return err
+	}
+	if len(b) == len([]rune(line)) {
+		return p.PrintLn(line) // no code page switches
+	}
+	return sendRaw(append(b, '\n')...)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected []byte
+	}{
+		{"Latin", "Crème €5", []byte("Cr\xe8me \xa45")},
+		{"Hebrew", "בלח 2", []byte("\x1bt1\xe1\xec\xe7 2\x1bt(")},
+		{"Mixed", "Milk ביח", []byte("Milk \x1bt1\xe1\xe9\xe7\x1bt(")},
+		{"Arabic then Hebrew", "با ב", []byte("\x1bt2\xc8\xc7 \x1bt1\xe1\x1bt(")},
+	}
+	for _, tt := range tests {
+		got, err := encodeLine(tt.line, nil)
+		if err != nil || !bytes.Equal(got, tt.expected) {
+			t.Errorf("%s: encodeLine(%q) = %q, %v, want %q", tt.name, tt.line, got, err, tt.expected)
+		}
+	}
+	if _, err := encodeLine("นม", nil); err == nil {
+		t.Errorf("encodeLine() of Thai = nil error")
+	}
+	if _, err := encodeLine("部品", nil); err == nil {
+		t.Errorf("encodeLine() of Kanji without a Kanji mode = nil error")
+	}
+	got, err := encodeLine("部品 4", kanjiCharsets["shift_jis"])
+	expected := []byte("\x1cC\x01\x1c&\x95\x94\x95\x69\x1c. 4")
+	if err != nil || !bytes.Equal(got, expected) {
+		t.Errorf("encodeLine() in Shift JIS = %q, %v, want %q", got, err, expected)
+	}
+}
+
+func TestPrintLineSwitchesCodePage(t *testing.T) {
+	f := useFakePrinter(t)
+	printLine(conf(), "Milk")
+	printLine(conf(), "חלב")
+	out := f.written.String()
+	if !strings.Contains(out, "Milk\n") || !strings.Contains(out, "\x1bt1\xe7\xec\xe1\x1bt(\n") {
+		t.Errorf("printed %q, want Milk as it is and the Hebrew in code page 1255", out)
+	}
+}
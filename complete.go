@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// lastProcessID numbers the completion requests so a late reply to an
+// earlier label is not mistaken for the current one
+var lastProcessID atomic.Uint32
+
+// processIDCommand builds a transmission request (GS ( H fn 48) asking the
+// printer to reply with id once everything sent before it has been printed
+func processIDCommand(id string) []byte {
+	return append([]byte{0x1D, 0x28, 0x48, 0x06, 0x00, 0x30, 0x30}, id...)
+}
+
+// processIDReply is what the printer sends back for a transmission request
+func processIDReply(id string) []byte {
+	reply := append([]byte{0x37, 0x22}, id...)
+	return append(reply, 0x00)
+}
+
+// confirmPrinted waits for the printer to report that everything sent so
+// far has been printed and returns when that happened.  The caller must
+// hold printMu.
+func confirmPrinted(timeout time.Duration) (time.Time, error) {
+	if dev == nil {
+		return time.Time{}, fmt.Errorf("printer not initialized")
+	}
+	if dev.dead.Load() {
+		return time.Time{}, errDisconnected
+	}
+	id := fmt.Sprintf("%04d", lastProcessID.Add(1)%10000)
+	dev.discardReplies()
+	if _, err := dev.Write(processIDCommand(id)); err != nil {
+		return time.Time{}, err
+	}
+	if err := dev.waitFor(processIDReply(id), timeout); err != nil {
+		return time.Time{}, fmt.Errorf("label not confirmed printed within %s: %w", timeout, err)
+	}
+	return time.Now(), nil
+}
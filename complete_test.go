@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestProcessIDCommand(t *testing.T) {
+	expected := []byte{0x1D, 0x28, 0x48, 0x06, 0x00, 0x30, 0x30, '0', '0', '4', '2'}
+	if got := processIDCommand("0042"); !bytes.Equal(got, expected) {
+		t.Errorf("processIDCommand() = % x, want % x", got, expected)
+	}
+	expected = []byte{0x37, 0x22, '0', '0', '4', '2', 0x00}
+	if got := processIDReply("0042"); !bytes.Equal(got, expected) {
+		t.Errorf("processIDReply() = % x, want % x", got, expected)
+	}
+}
+
+func TestConfirmPrinted(t *testing.T) {
+	f := useFakePrinter(t)
+	before := time.Now()
+	printed, err := confirmPrinted(time.Second)
+	if err != nil {
+		t.Fatalf("confirmPrinted() error = %v", err)
+	}
+	if printed.Before(before) {
+		t.Errorf("confirmPrinted() = %v, before the request was sent", printed)
+	}
+
+	f.silent = true
+	if _, err := confirmPrinted(50 * time.Millisecond); err == nil {
+		t.Errorf("confirmPrinted() with no reply did not time out")
+	}
+}
+
+func TestWaitForSkipsOtherReplies(t *testing.T) {
+	f := useFakePrinter(t)
+	f.replies <- []byte{0x12, 0x37, 0x22, '0', '0', '0', '1', 0x00}
+	f.replies <- []byte{0x37, 0x37, 0x22, '0', '0', '0', '2', 0x00}
+	if err := dev.waitFor(processIDReply("0002"), time.Second); err != nil {
+		t.Errorf("waitFor() error = %v", err)
+	}
+}
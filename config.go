@@ -0,0 +1,483 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 2 * time.Second
+
+// config is everything that can be set in the config file.  Settings not in
+// the file come from the command line flags, and flags given on the command
+// line override the file.
+type config struct {
+	Server   serverConfig          `toml:"server"`
+	Printer  printerConfig         `toml:"printer"`
+	Template templateConfig        `toml:"template"`
+	Defaults defaultsConfig        `toml:"defaults"`
+	Security securityConfig        `toml:"security"`
+	Limits   limitsConfig          `toml:"limits"`
+	Audit    auditConfig           `toml:"audit"`
+	Retry    retryConfig           `toml:"retry"`
+	Roles    map[string]roleConfig `toml:"roles"`
+
+	// worked out from the settings by validate
+	media mediaProfile
+	tabs  tabStopList
+	kanji *kanjiCharset // nil when the printer has no Kanji mode
+	auth  authenticator // nil when auth is none
+	roles map[string]role
+}
+
+type serverConfig struct {
+	Port         int    `toml:"port"`
+	StateDir     string `toml:"state_dir"`
+	TLS          string `toml:"tls"` // off, files or self-signed
+	HTTPSPort    int    `toml:"https_port"`
+	CertFile     string `toml:"cert_file"`
+	KeyFile      string `toml:"key_file"`
+	RedirectHTTP bool   `toml:"redirect_http"` // send plain HTTP to HTTPS
+}
+
+type printerConfig struct {
+	Device       string   `toml:"device"`
+	Name         string   `toml:"name"`
+	Media        string   `toml:"media"`
+	LabelLength  float64  `toml:"label_length"`
+	Overflow     string   `toml:"overflow"`
+	RollLength   float64  `toml:"roll_length"`
+	PaperWarn    float64  `toml:"paper_warn"`
+	PulsePin     int      `toml:"pulse_pin"`
+	PrintTimeout duration `toml:"print_timeout"`
+	Kanji        string   `toml:"kanji"` // character set of the Kanji mode, empty for none
+}
+
+// templateConfig is the layout of the task label
+type templateConfig struct {
+	Heading         string  `toml:"heading"` // empty for no heading
+	TimestampFormat string  `toml:"timestamp_format"`
+	LineLength      int     `toml:"line_length"` // characters per line at the normal size
+	Tabs            string  `toml:"tabs"`
+	FitLines        int     `toml:"fit_lines"`
+	FitLength       float64 `toml:"fit_length"`
+}
+
+// defaultsConfig fills in the print form
+type defaultsConfig struct {
+	Barcode int    `toml:"barcode"`
+	Copies  int    `toml:"copies"`
+	Cut     string `toml:"cut"`
+	CutAt   string `toml:"cut_at"`
+	Fit     bool   `toml:"fit"`
+	Alert   string `toml:"alert"`
+}
+
+// securityConfig says who can print and limits what one request can print
+type securityConfig struct {
+	Auth             string            `toml:"auth"`          // none, password or users
+	PasswordHash     string            `toml:"password_hash"` // bcrypt hash of the shared password
+	UsersFile        string            `toml:"users_file"`
+	APITokens        map[string]string `toml:"api_tokens"` // name to SHA-256 of the token
+	SessionLifetime  duration          `toml:"session_lifetime"`
+	MaxCopies        int               `toml:"max_copies"`
+	MaxBatch         int               `toml:"max_batch"`
+	MaxRequestBytes  int64             `toml:"max_request_bytes"`
+	MaxMessageLength int               `toml:"max_message_length"` // characters
+	MaxMessageLines  int               `toml:"max_message_lines"`
+	DefaultRole      string            `toml:"default_role"` // for anyone not in user_roles or token_roles
+	UserRoles        map[string]string `toml:"user_roles"`   // user name to role
+	TokenRoles       map[string]string `toml:"token_roles"`  // API token name to role
+}
+
+// limitsConfig limits how much each client can print.  Zero turns a limit
+// off.
+type limitsConfig struct {
+	IPPerMinute    int     `toml:"ip_per_minute"` // labels
+	TokenPerMinute int     `toml:"token_per_minute"`
+	UserPerMinute  int     `toml:"user_per_minute"`
+	DailyPaper     float64 `toml:"daily_paper"` // metres per user or token, or per IP without auth
+}
+
+// auditConfig is where the audit log goes and how much of it is kept
+type auditConfig struct {
+	File    string `toml:"file"`     // empty for audit.jsonl in the state directory
+	MaxSize int    `toml:"max_size"` // MB before a new file is started
+	Keep    int    `toml:"keep"`     // old files kept
+}
+
+// retryConfig is how often and how soon a failed job is tried again
+type retryConfig struct {
+	MaxAttempts int      `toml:"max_attempts"` // tries before a job goes on the failed list
+	Backoff     duration `toml:"backoff"`      // wait before the first retry, doubled each time
+	MaxBackoff  duration `toml:"max_backoff"`
+}
+
+// duration is a time.Duration written as a string such as "30s"
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// configPath is the file the config was loaded from, empty for none
+var configPath = flag.String("config", "", "Config file (TOML), reloaded on SIGHUP or when it changes")
+
+// currentConfig is the config in use, swapped whole on reload
+var currentConfig atomic.Pointer[config]
+
+func init() {
+	c := defaultConfig()
+	copyFlags(c, flag.VisitAll)
+	if err := c.validate(); err != nil {
+		panic(err)
+	}
+	currentConfig.Store(c)
+}
+
+// conf returns the config in use.  Callers that use several settings
+// together should call it once so a reload cannot mix old and new.
+func conf() *config {
+	return currentConfig.Load()
+}
+
+// defaultConfig returns the settings that have no command line flag
+func defaultConfig() *config {
+	return &config{
+		Server: serverConfig{
+			RedirectHTTP: true,
+		},
+		Template: templateConfig{
+			Heading:         "Task",
+			TimestampFormat: "2006-01-02T15:04:05Z",
+			LineLength:      maxLineLength,
+		},
+		Defaults: defaultsConfig{
+			Barcode: 5,
+			Copies:  1,
+			Cut:     string(cutFull),
+			CutAt:   string(cutBetween),
+		},
+		Security: securityConfig{
+			Auth:             string(authNone),
+			SessionLifetime:  duration{12 * time.Hour},
+			MaxCopies:        maxCopies,
+			MaxBatch:         maxBatch,
+			MaxRequestBytes:  64 << 10,
+			MaxMessageLength: 2000,
+			MaxMessageLines:  50,
+			DefaultRole:      "admin",
+		},
+		Audit: auditConfig{
+			MaxSize: 10,
+			Keep:    5,
+		},
+		Retry: retryConfig{
+			MaxAttempts: 3,
+			Backoff:     duration{5 * time.Second},
+			MaxBackoff:  duration{5 * time.Minute},
+		},
+		Roles: builtinRoles(),
+	}
+}
+
+// flagFields maps each command line flag to the setting it fills in
+func (c *config) flagFields() map[string]any {
+	return map[string]any{
+		"port":          &c.Server.Port,
+		"state-dir":     &c.Server.StateDir,
+		"tls":           &c.Server.TLS,
+		"https-port":    &c.Server.HTTPSPort,
+		"printer":       &c.Printer.Device,
+		"printer-name":  &c.Printer.Name,
+		"media":         &c.Printer.Media,
+		"label-length":  &c.Printer.LabelLength,
+		"overflow":      &c.Printer.Overflow,
+		"roll-length":   &c.Printer.RollLength,
+		"paper-warn":    &c.Printer.PaperWarn,
+		"pulse-pin":     &c.Printer.PulsePin,
+		"print-timeout": &c.Printer.PrintTimeout,
+		"tabs":          &c.Template.Tabs,
+		"fit-lines":     &c.Template.FitLines,
+		"fit-length":    &c.Template.FitLength,
+	}
+}
+
+// copyFlags copies the flags visited by visit into c, flag.VisitAll for
+// every flag or flag.Visit for those given on the command line
+func copyFlags(c *config, visit func(func(*flag.Flag))) {
+	fields := c.flagFields()
+	visit(func(f *flag.Flag) {
+		field, ok := fields[f.Name]
+		if !ok {
+			return
+		}
+		value := f.Value.(flag.Getter).Get()
+		switch field := field.(type) {
+		case *int:
+			*field = value.(int)
+		case *float64:
+			*field = value.(float64)
+		case *string:
+			*field = value.(string)
+		case *duration:
+			field.Duration = value.(time.Duration)
+		}
+	})
+}
+
+// loadConfig builds the config from the flags and the file at path, if any
+func loadConfig(path string) (*config, error) {
+	c := defaultConfig()
+	copyFlags(c, flag.VisitAll)
+	if path != "" {
+		md, err := toml.DecodeFile(path, c)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, k := range undecoded {
+				keys[i] = k.String()
+			}
+			return nil, fmt.Errorf("config %s: unknown settings %s", path, strings.Join(keys, ", "))
+		}
+	}
+	copyFlags(c, flag.Visit)
+	if err := c.validate(); err != nil {
+		if path != "" {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// validate checks the settings and works out the derived ones
+func (c *config) validate() error {
+	var err error
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		return fmt.Errorf("server.port %d is not a valid port", c.Server.Port)
+	}
+	switch c.Server.TLS {
+	case tlsOff:
+	case tlsFiles:
+		if c.Server.CertFile == "" || c.Server.KeyFile == "" {
+			return fmt.Errorf("server.cert_file and key_file are needed for tls = %q", tlsFiles)
+		}
+	case tlsSelfSigned:
+		if c.Server.StateDir == "" {
+			return fmt.Errorf("server.state_dir is needed to keep a self-signed certificate")
+		}
+	default:
+		return fmt.Errorf("server.tls must be off, files or self-signed, not %q", c.Server.TLS)
+	}
+	if c.Server.TLS != tlsOff && (c.Server.HTTPSPort < 1 || c.Server.HTTPSPort > 65535 || c.Server.HTTPSPort == c.Server.Port) {
+		return fmt.Errorf("server.https_port %d is not a valid port or is the same as server.port", c.Server.HTTPSPort)
+	}
+	if c.Printer.Name == "" {
+		return fmt.Errorf("printer.name cannot be empty")
+	}
+	if c.media, err = newMediaProfile(c.Printer.Media, c.Printer.LabelLength, c.Printer.Overflow); err != nil {
+		return fmt.Errorf("printer: %w", err)
+	}
+	if err := checkRollLength(c.Printer.RollLength); err != nil {
+		return fmt.Errorf("printer.roll_length: %w", err)
+	}
+	if c.Printer.PulsePin != 2 && c.Printer.PulsePin != 5 {
+		return fmt.Errorf("printer.pulse_pin must be 2 or 5, not %d", c.Printer.PulsePin)
+	}
+	if c.Printer.PrintTimeout.Duration <= 0 {
+		return fmt.Errorf("printer.print_timeout must be more than 0")
+	}
+	if c.Printer.Kanji != "" {
+		if c.kanji = kanjiCharsets[c.Printer.Kanji]; c.kanji == nil {
+			return fmt.Errorf("printer.kanji must be empty, shift_jis, gb18030, big5 or euc_kr, not %q", c.Printer.Kanji)
+		}
+	}
+
+	if c.Template.LineLength < 1 || c.Template.LineLength > lineWidth(2) {
+		return fmt.Errorf("template.line_length must be between 1 and %d", lineWidth(2))
+	}
+	if err := checkPrintable(c.Template.Heading, c.kanji); err != nil {
+		return fmt.Errorf("template.heading %w", err)
+	}
+	if c.Template.TimestampFormat == "" {
+		return fmt.Errorf("template.timestamp_format cannot be empty")
+	}
+	if c.tabs, err = parseTabStops(c.Template.Tabs); err != nil {
+		return fmt.Errorf("template.tabs: %w", err)
+	}
+	if c.Template.FitLines < 0 || c.Template.FitLength < 0 {
+		return fmt.Errorf("template.fit_lines and fit_length cannot be negative")
+	}
+
+	if c.auth, err = newAuthenticator(c.Security); err != nil {
+		return err
+	}
+	for name, hash := range c.Security.APITokens {
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("security.api_tokens.%s must be a hex SHA-256 hash", name)
+		}
+	}
+	if c.Security.SessionLifetime.Duration <= 0 {
+		return fmt.Errorf("security.session_lifetime must be more than 0")
+	}
+	if c.Security.MaxCopies < 1 || c.Security.MaxBatch < 1 {
+		return fmt.Errorf("security.max_copies and max_batch must be at least 1")
+	}
+	if c.Security.MaxRequestBytes < 1024 {
+		return fmt.Errorf("security.max_request_bytes must be at least 1024")
+	}
+	if c.Security.MaxMessageLength < 1 || c.Security.MaxMessageLines < 1 {
+		return fmt.Errorf("security.max_message_length and max_message_lines must be at least 1")
+	}
+	if c.Limits.IPPerMinute < 0 || c.Limits.TokenPerMinute < 0 || c.Limits.UserPerMinute < 0 || c.Limits.DailyPaper < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+
+	if c.Audit.MaxSize < 1 || c.Audit.Keep < 1 {
+		return fmt.Errorf("audit.max_size and keep must be at least 1")
+	}
+	if c.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("retry.max_attempts must be at least 1")
+	}
+	if c.Retry.Backoff.Duration <= 0 || c.Retry.MaxBackoff.Duration < c.Retry.Backoff.Duration {
+		return fmt.Errorf("retry.backoff must be positive and no more than max_backoff")
+	}
+
+	c.roles = map[string]role{}
+	for name, rc := range c.Roles {
+		if c.roles[name], err = newRole(name, rc); err != nil {
+			return err
+		}
+	}
+	if _, ok := c.roles[c.Security.DefaultRole]; !ok {
+		return fmt.Errorf("security.default_role %q is not a role", c.Security.DefaultRole)
+	}
+	if len(c.Security.UserRoles) > 0 && authMode(c.Security.Auth) == authPassword {
+		return fmt.Errorf("security.user_roles cannot be used with auth = \"password\" as anyone can log in under any name")
+	}
+	for user, name := range c.Security.UserRoles {
+		if _, ok := c.roles[name]; !ok {
+			return fmt.Errorf("security.user_roles.%s: %q is not a role", user, name)
+		}
+	}
+	for token, name := range c.Security.TokenRoles {
+		if _, ok := c.Security.APITokens[token]; !ok {
+			return fmt.Errorf("security.token_roles.%s: no such API token", token)
+		}
+		if _, ok := c.roles[name]; !ok {
+			return fmt.Errorf("security.token_roles.%s: %q is not a role", token, name)
+		}
+	}
+
+	if c.Defaults.Barcode < 1 {
+		return fmt.Errorf("defaults.barcode must be at least 1")
+	}
+	if c.Defaults.Copies < 1 || c.Defaults.Copies > c.Security.MaxCopies {
+		return fmt.Errorf("defaults.copies must be between 1 and %d", c.Security.MaxCopies)
+	}
+	if _, err := parseCutMode(c.Defaults.Cut); err != nil {
+		return fmt.Errorf("defaults.cut: %w", err)
+	}
+	if _, err := parseCutPlacement(c.Defaults.CutAt); err != nil {
+		return fmt.Errorf("defaults.cut_at: %w", err)
+	}
+	if _, err := parseAlert(c.Defaults.Alert); err != nil {
+		return fmt.Errorf("defaults.alert: %w", err)
+	}
+	return nil
+}
+
+// reloadConfig loads the config file again and, if it is valid, switches to
+// it.  Queued jobs are untouched and print with the new settings.
+func reloadConfig(path string) error {
+	c, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	old := conf()
+	if c.Server != old.Server {
+		fmt.Println("Server settings changed, restart golabel to use them")
+		c.Server = old.Server
+	}
+	currentConfig.Store(c)
+	fmt.Println("Config reloaded from", path)
+	return nil
+}
+
+// handleReload reloads the config file from the web page
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if *configPath == "" {
+		renderPage(w, r, "There is no config file to reload", false)
+		return
+	}
+	e := newAuditEvent(r, auditConfigChange)
+	if err := reloadConfig(*configPath); err != nil {
+		e.Detail = "reload failed: " + err.Error()
+		audit.record(e)
+		renderPage(w, r, "Error: "+err.Error(), false)
+		return
+	}
+	e.Detail = "reloaded " + *configPath + " from the web page"
+	audit.record(e)
+	renderPage(w, r, "Config reloaded", true)
+}
+
+// watchConfig reloads the config file on SIGHUP or when it is changed.  A
+// file with errors is reported and the old config kept.
+func watchConfig(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	modified := func() time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	last := modified()
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			if m := modified(); m.IsZero() || m.Equal(last) {
+				continue
+			}
+		}
+		last = modified()
+		e := auditEvent{Time: time.Now(), Action: auditConfigChange, Detail: "reloaded " + path}
+		if err := reloadConfig(path); err != nil {
+			fmt.Println("Error reloading config, keeping the old one:", err)
+			e.Detail = "reload failed: " + err.Error()
+		}
+		audit.record(e)
+	}
+}
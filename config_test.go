@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// keep tests out of the real state directory
+	flag.Lookup("state-dir").Value.Set("")
+	c := *conf()
+	c.Server.StateDir = ""
+	currentConfig.Store(&c)
+	os.Exit(m.Run())
+}
+
+// writeConfig writes a config file for one test
+func writeConfig(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "golabel.toml")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// useConfig restores the config in use when a test finishes
+func useConfig(t *testing.T) {
+	t.Helper()
+	saved := conf()
+	t.Cleanup(func() { currentConfig.Store(saved) })
+}
+
+// useConfigWith changes a copy of the config in use for one test,
+// validating it as a reload would
+func useConfigWith(t *testing.T, change func(c *config)) {
+	t.Helper()
+	useConfig(t)
+	c := *conf()
+	change(&c)
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	currentConfig.Store(&c)
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+[server]
+port = 8080
+
+[printer]
+name = "shed"
+media = "gap"
+label_length = 50
+print_timeout = "10s"
+
+[template]
+heading = "Asset"
+tabs = "8"
+
+[defaults]
+copies = 2
+cut = "partial"
+`)
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Port != 8080 || c.Printer.Name != "shed" || c.Template.Heading != "Asset" {
+		t.Errorf("loadConfig() = %+v, want the settings from the file", c)
+	}
+	if c.media.Type != mediaGap || c.media.LengthMM != 50 || c.tabs.interval != 8 {
+		t.Errorf("derived media %+v tabs %+v, want 50mm gap and tabs every 8", c.media, c.tabs)
+	}
+	if c.Printer.PrintTimeout.Duration != 10*time.Second || c.Defaults.Cut != "partial" {
+		t.Errorf("print timeout %v cut %q, want 10s and partial", c.Printer.PrintTimeout, c.Defaults.Cut)
+	}
+	// settings not in the file come from the flags
+	if c.Printer.RollLength != 80 || c.Template.LineLength != maxLineLength || c.Defaults.Barcode != 5 {
+		t.Errorf("loadConfig() defaults = %+v, want flag and built in defaults", c)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"Syntax", "[server\nport = 1", "golabel.toml"},
+		{"Unknown setting", "[printer]\nmedium = \"gap\"", "printer.medium"},
+		{"Wrong type", "[server]\nport = \"eighty\"", "port"},
+		{"Unknown TLS mode", "[server]\ntls = \"acme\"", "server.tls"},
+		{"TLS files missing", "[server]\ntls = \"files\"", "cert_file"},
+		{"HTTPS on the HTTP port", "[server]\ntls = \"self-signed\"\nstate_dir = \"state\"\nport = 443", "https_port"},
+		{"Bad media", "[printer]\nmedia = \"fanfold\"", "fanfold"},
+		{"Gap without length", "[printer]\nmedia = \"gap\"", "label length"},
+		{"Bad timeout", "[printer]\nprint_timeout = \"soon\"", "soon"},
+		{"Bad pulse pin", "[printer]\npulse_pin = 3", "pulse_pin"},
+		{"Infinite roll", "[printer]\nroll_length = inf", "roll_length"},
+		{"Unknown Kanji character set", "[printer]\nkanji = \"utf8\"", "printer.kanji"},
+		{"Bad tabs", "[template]\ntabs = \"8,4\"", "template.tabs"},
+		{"Line too long", "[template]\nline_length = 100", "line_length"},
+		{"Bad default cut", "[defaults]\ncut = \"half\"", "defaults.cut"},
+		{"Copies over limit", "[defaults]\ncopies = 5\n[security]\nmax_copies = 2", "defaults.copies"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, tt.text))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("loadConfig() error = %v, want it to mention %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	useConfig(t)
+	path := writeConfig(t, "[server]\nport = 9000\n[template]\nheading = \"Asset\"")
+	if err := reloadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	c := conf()
+	if c.Template.Heading != "Asset" {
+		t.Errorf("heading after reload = %q, want Asset", c.Template.Heading)
+	}
+	if c.Server.Port == 9000 {
+		t.Errorf("server port changed on reload, it needs a restart")
+	}
+
+	// a broken file leaves the config alone
+	if err := os.WriteFile(path, []byte("[printer]\nmedia = \"fanfold\""), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(path); err == nil {
+		t.Error("reloadConfig() with a bad file should fail")
+	}
+	if conf() != c {
+		t.Error("config replaced by a file with errors")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronLookahead is how far ahead the next time of a cron expression is
+// looked for before giving up, for expressions like 30 February
+const cronLookahead = 5 * 366 * 24 * time.Hour
+
+// cronShortcuts are the @ names for common expressions
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames   = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	weekdayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// cronSpec is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week.  Each field is a bit set of the values it
+// matches.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // the field was *
+}
+
+// parseCron parses a cron expression such as "30 7 * * mon-fri" or one of
+// the @ shortcuts
+func parseCron(expr string) (cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if shortcut, ok := cronShortcuts[strings.ToLower(expr)]; ok {
+		expr = shortcut
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSpec{}, fmt.Errorf("cron expression %q needs 5 fields: minute hour day month weekday", expr)
+	}
+	var c cronSpec
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return c, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return c, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return c, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return c, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return c, fmt.Errorf("weekday: %w", err)
+	}
+	if c.dow&(1<<7) != 0 { // 7 is Sunday too
+		c.dow |= 1
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of *, values and ranges,
+// each with an optional /step
+func parseCronField(field string, low, high int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+		}
+		lo, hi := low, high
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = cronValue(first, low, high, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(last, low, high, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = high
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("range %q goes backwards", rangePart)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// cronValue parses one number or name in a cron field
+func cronValue(s string, low, high int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < low || v > high {
+		return 0, fmt.Errorf("%q is not between %d and %d", s, low, high)
+	}
+	return v, nil
+}
+
+// matchesDay reports whether the spec runs on t's day.  As in cron, when
+// both day of month and weekday are given either will do.
+func (c cronSpec) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first time after after that the spec matches, or the
+// zero time if there is none in the next few years
+func (c cronSpec) next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute()+1, 0, 0, loc)
+	limit := after.Add(cronLookahead)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// Saturday 1 March 2025, 09:15
+	after := time.Date(2025, 3, 1, 9, 15, 0, 0, time.UTC)
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{"* * * * *", "2025-03-01 09:16"},
+		{"30 7 * * *", "2025-03-02 07:30"},
+		{"30 7 * * mon-fri", "2025-03-03 07:30"},
+		{"0 9 * * 1,3", "2025-03-03 09:00"},
+		{"*/20 * * * *", "2025-03-01 09:20"},
+		{"0 */6 * * *", "2025-03-01 12:00"},
+		{"0 0 1 * *", "2025-04-01 00:00"},
+		{"0 12 15 jun *", "2025-06-15 12:00"},
+		{"0 8 13 * fri", "2025-03-07 08:00"}, // day of month or weekday
+		{"0 10 * * 7", "2025-03-02 10:00"},   // 7 is Sunday
+		{"@daily", "2025-03-02 00:00"},
+		{"@hourly", "2025-03-01 10:00"},
+		{"0 0 29 2 *", "2028-02-29 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			spec, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := spec.next(after).Format("2006-01-02 15:04"); got != tt.expected {
+				t.Errorf("next(%q) = %s, want %s", tt.expr, got, tt.expected)
+			}
+		})
+	}
+
+	spec, _ := parseCron("0 0 30 2 *")
+	if got := spec.next(after); !got.IsZero() {
+		t.Errorf("next(30 February) = %v, want none", got)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"* * * * someday",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) = nil error", expr)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// csrfCookie holds the token that forms must send back, so a page on
+// another site cannot post to golabel from someone's browser
+const csrfCookie = "golabel_csrf"
+
+// csrfField is the hidden form field carrying the token
+const csrfField = "csrf_token"
+
+// csrfToken returns the CSRF token for the browser, setting a new one if it
+// has none.  It must be called before anything is written to w.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && len(cookie.Value) == 43 {
+		return cookie.Value
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// validCSRF reports whether a form was posted with the token from its cookie
+func validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil {
+		return false
+	}
+	sent := r.PostFormValue(csrfField)
+	return sent != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(cookie.Value)) == 1
+}
+
+// checkOrigin refuses requests sent from a page on another site.  Browsers
+// send Origin, or at least Referer, with a POST; scripts that send neither
+// are let through.
+func checkOrigin(r *http.Request) error {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return nil
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host != r.Host {
+		return fmt.Errorf("cross-site request from %q refused", source)
+	}
+	return nil
+}
+
+// limitBody caps the size of a request body
+func limitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, conf().Security.MaxRequestBytes)
+}
+
+// checkForm guards a handler for form posts: the request must come from
+// golabel's own pages, fit the size limit and carry the CSRF token
+func checkForm(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			limitBody(w, r)
+			if err := checkOrigin(r); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if err := r.ParseForm(); err != nil {
+				var tooBig *http.MaxBytesError
+				if errors.As(err, &tooBig) {
+					http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !validCSRF(r) {
+				http.Error(w, "form expired, reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// checkAPI guards a JSON API handler.  Insisting on a JSON content type
+// means a browser cannot send the request from another site without a
+// preflight, which golabel does not answer.
+func checkAPI(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			limitBody(w, r)
+			if err := checkOrigin(r); err != nil {
+				writeJSONError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+				writeJSONError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		referer string
+		wantErr bool
+	}{
+		{"Script", "", "", false},
+		{"Same origin", "http://golabel.lan", "", false},
+		{"Same referer", "", "http://golabel.lan/print", false},
+		{"Other origin", "http://evil.example", "", true},
+		{"Other referer", "", "http://evil.example/page", true},
+		{"Origin wins", "http://evil.example", "http://golabel.lan/", true},
+		{"Null origin", "null", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "http://golabel.lan/print", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			if err := checkOrigin(r); (err != nil) != tt.wantErr {
+				t.Errorf("checkOrigin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckForm(t *testing.T) {
+	handler := checkForm(func(w http.ResponseWriter, r *http.Request) {})
+
+	// a page view hands out the token
+	w := httptest.NewRecorder()
+	token := csrfToken(w, httptest.NewRequest("GET", "/", nil))
+	cookie := w.Result().Cookies()[0]
+
+	post := func(form url.Values, origin string) int {
+		r := httptest.NewRequest("POST", "http://golabel.lan/print", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookie)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		origin   string
+		expected int
+	}{
+		{"With token", url.Values{"message": {"Milk"}, csrfField: {token}}, "http://golabel.lan", http.StatusOK},
+		{"No token", url.Values{"message": {"Milk"}}, "", http.StatusForbidden},
+		{"Wrong token", url.Values{"message": {"Milk"}, csrfField: {"guess"}}, "", http.StatusForbidden},
+		{"Cross site", url.Values{"message": {"Milk"}, csrfField: {token}}, "http://evil.example", http.StatusForbidden},
+		{"Too large", url.Values{"message": {strings.Repeat("x", 70000)}, csrfField: {token}}, "", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := post(tt.form, tt.origin); code != tt.expected {
+				t.Errorf("POST = %d, want %d", code, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckAPI(t *testing.T) {
+	handler := checkAPI(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		contentType string
+		expected    int
+	}{
+		{"application/json", http.StatusOK},
+		{"application/json; charset=utf-8", http.StatusOK},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/print", strings.NewReader(`{"message":"Milk"}`))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.expected {
+				t.Errorf("POST with %q = %d, want %d", tt.contentType, w.Code, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+)
+
+// cutMode is how the autocutter finishes a label
+type cutMode string
+
+const (
+	cutFull    cutMode = "full"
+	cutPartial cutMode = "partial" // leaves a tab so labels tear off in a strip
+	cutNone    cutMode = "none"    // feed to the tear bar without cutting
+)
+
+// cutPlacement is where the cuts go in a job of several labels
+type cutPlacement string
+
+const (
+	cutBetween cutPlacement = "between" // after every label
+	cutAtEnd   cutPlacement = "end"     // once, after the last label
+)
+
+// Default limits on the size of one job
+const (
+	maxCopies = 20
+	maxBatch  = 100
+)
+
+// tearFeedLines feeds the last label past the tear bar when not cutting
+const tearFeedLines = 4
+
+// labelSpec is one label of a job
+type labelSpec struct {
+	Message string
+	Barcode int
+}
+
+// parseCutMode checks a cut mode named in a form or API request, empty
+// meaning a full cut
+func parseCutMode(s string) (cutMode, error) {
+	switch c := cutMode(s); c {
+	case cutFull, cutPartial, cutNone:
+		return c, nil
+	case "":
+		return cutFull, nil
+	}
+	return cutFull, fmt.Errorf("unknown cut %q", s)
+}
+
+// parseCutPlacement checks a cut placement named in a form or API request,
+// empty meaning a cut between labels
+func parseCutPlacement(s string) (cutPlacement, error) {
+	switch c := cutPlacement(s); c {
+	case cutBetween, cutAtEnd:
+		return c, nil
+	case "":
+		return cutBetween, nil
+	}
+	return cutBetween, fmt.Errorf("unknown cut placement %q", s)
+}
+
+// checkJobSize checks the number of labels and copies in a job
+func checkJobSize(labels, copies int) error {
+	security := conf().Security
+	switch {
+	case labels == 0:
+		return fmt.Errorf("no labels to print")
+	case labels > security.MaxBatch:
+		return fmt.Errorf("at most %d labels in one job", security.MaxBatch)
+	case copies < 1 || copies > security.MaxCopies:
+		return fmt.Errorf("copies must be between 1 and %d", security.MaxCopies)
+	}
+	return nil
+}
+
+// barcodeRun makes a label for each barcode from first to last with the
+// same message, for printing a run of asset tags
+func barcodeRun(message string, first, last int) ([]labelSpec, error) {
+	if last == 0 {
+		last = first
+	}
+	if err := checkBarcode(first); err != nil {
+		return nil, err
+	}
+	if err := checkBarcode(last); err != nil {
+		return nil, err
+	}
+	if last < first {
+		return nil, fmt.Errorf("last barcode %d is before the first %d", last, first)
+	}
+	if limit := conf().Security.MaxBatch; last-first >= limit {
+		return nil, fmt.Errorf("at most %d labels in one job", limit)
+	}
+	labels := make([]labelSpec, 0, last-first+1)
+	for n := first; n <= last; n++ {
+		labels = append(labels, labelSpec{Message: message, Barcode: n})
+	}
+	return labels, nil
+}
+
+// cut finishes a label, returning the number of cuts made.  Full and partial
+// cuts feed the label past the cutter first (GS V 65/66).  The caller must
+// hold printMu.
+func cut(mode cutMode) (int, error) {
+	switch mode {
+	case cutNone:
+		return 0, p.Feed(tearFeedLines)
+	case cutPartial:
+		return 1, sendRaw(0x1D, 0x56, 0x42, 0x30)
+	}
+	return 1, p.Cut()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseLabelOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   int
+		copies   int
+		cut      string
+		cutAt    string
+		expected labelOptions
+		wantErr  bool
+	}{
+		{"Defaults", 1, 0, "", "", labelOptions{Copies: 1, Cut: cutFull, CutAt: cutBetween}, false},
+		{"Partial at end", 3, 2, "partial", "end", labelOptions{Copies: 2, Cut: cutPartial, CutAt: cutAtEnd}, false},
+		{"No cut", 1, 1, "none", "between", labelOptions{Copies: 1, Cut: cutNone, CutAt: cutBetween}, false},
+		{"Unknown cut", 1, 1, "half", "", labelOptions{}, true},
+		{"Unknown placement", 1, 1, "", "middle", labelOptions{}, true},
+		{"Negative copies", 1, -1, "", "", labelOptions{}, true},
+		{"Too many copies", 1, maxCopies + 1, "", "", labelOptions{}, true},
+		{"Too many labels", maxBatch + 1, 1, "", "", labelOptions{}, true},
+		{"No labels", 0, 1, "", "", labelOptions{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseLabelOptions(tt.labels, false, "", tt.copies, tt.cut, tt.cutAt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLabelOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("parseLabelOptions() = %+v, want %+v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBarcodeRun(t *testing.T) {
+	labels, err := barcodeRun("Asset", 100, 103)
+	if err != nil || len(labels) != 4 || labels[0].Barcode != 100 || labels[3].Barcode != 103 {
+		t.Errorf("barcodeRun(100, 103) = %v, %v", labels, err)
+	}
+	if labels, err := barcodeRun("Asset", 7, 0); err != nil || len(labels) != 1 || labels[0].Barcode != 7 {
+		t.Errorf("barcodeRun(7, 0) = %v, %v, want one label", labels, err)
+	}
+	if _, err := barcodeRun("Asset", 10, 9); err == nil {
+		t.Error("barcodeRun(10, 9) should fail")
+	}
+	if _, err := barcodeRun("Asset", 1, maxBatch+1); err == nil {
+		t.Error("barcodeRun() should refuse a run longer than a batch")
+	}
+}
+
+func TestPrintLabelsCuts(t *testing.T) {
+	fullCut := []byte("\x1DVA0")
+	partialCut := []byte{0x1D, 0x56, 0x42, 0x30}
+	labels := []labelSpec{{"One", 1}, {"Two", 2}}
+
+	tests := []struct {
+		name  string
+		opts  labelOptions
+		cut   []byte
+		cuts  int
+		lines int
+	}{
+		{"Full between copies", labelOptions{Copies: 2}, fullCut, 4, 4},
+		{"Partial at end", labelOptions{Copies: 3, Cut: cutPartial, CutAt: cutAtEnd}, partialCut, 1, 6},
+		{"No cut", labelOptions{Cut: cutNone}, fullCut, 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakePrinter(t)
+			result, err := printLabels(labels, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Cuts != tt.cuts || result.Lines != tt.lines {
+				t.Errorf("printLabels() cuts %d lines %d, want %d and %d", result.Cuts, result.Lines, tt.cuts, tt.lines)
+			}
+			if n := bytes.Count(f.written.Bytes(), tt.cut); n != tt.cuts {
+				t.Errorf("sent %d cut commands, want %d", n, tt.cuts)
+			}
+		})
+	}
+}
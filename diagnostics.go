@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drummonds/golabel/version"
+)
+
+// sysUSBDevices is where Linux lists the USB devices plugged in
+const sysUSBDevices = "/sys/bus/usb/devices"
+
+// lastOpenError is the most recent failure to open the printer, guarded by
+// printMu and deviceMu
+var lastOpenError struct {
+	Err  string
+	When time.Time
+}
+
+// usbDevice is a USB device as described by sysfs
+type usbDevice struct {
+	Bus          string
+	VendorID     string
+	ProductID    string
+	Manufacturer string
+	Product      string
+}
+
+// readSysAttr reads a single sysfs attribute, empty if it is missing
+func readSysAttr(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// listUSBDevices lists the USB devices under sysDir, skipping interfaces and
+// anything else without a vendor ID
+func listUSBDevices(sysDir string) []usbDevice {
+	entries, err := os.ReadDir(sysDir)
+	if err != nil {
+		return nil
+	}
+	var devices []usbDevice
+	for _, entry := range entries {
+		dir := filepath.Join(sysDir, entry.Name())
+		vendor := readSysAttr(dir, "idVendor")
+		if vendor == "" {
+			continue
+		}
+		devices = append(devices, usbDevice{
+			Bus:          entry.Name(),
+			VendorID:     vendor,
+			ProductID:    readSysAttr(dir, "idProduct"),
+			Manufacturer: readSysAttr(dir, "manufacturer"),
+			Product:      readSysAttr(dir, "product"),
+		})
+	}
+	sort.Slice(devices, func(i, k int) bool { return devices[i].Bus < devices[k].Bus })
+	return devices
+}
+
+// listPrinterNodes lists the printer device nodes the usblp driver created
+func listPrinterNodes() []string {
+	entries, err := os.ReadDir(usbDevDir)
+	if err != nil {
+		return nil
+	}
+	var nodes []string
+	for _, entry := range entries {
+		nodes = append(nodes, filepath.Join(usbDevDir, entry.Name()))
+	}
+	return nodes
+}
+
+// DiagnosticsData is shown on the diagnostics page
+type DiagnosticsData struct {
+	Version       string
+	Printer       printerStatus
+	DevicePath    string
+	WantPath      string
+	LastOpenError string
+	LastOpenTime  time.Time
+	Queued        int
+	PrinterNodes  []string
+	USBDevices    []usbDevice
+}
+
+// handleDiagnostics shows what golabel can see of the printer, to help work
+// out why it is offline
+func handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	data := DiagnosticsData{
+		Version:      version.GetVersionInfo(),
+		Printer:      checkStatus(),
+		WantPath:     conf().Printer.Device,
+		Queued:       queue.depth(),
+		PrinterNodes: listPrinterNodes(),
+		USBDevices:   listUSBDevices(sysUSBDevices),
+	}
+	var openErr errorSummary
+	data.DevicePath, openErr = deviceState()
+	data.LastOpenError = openErr.Message
+	data.LastOpenTime = openErr.When
+
+	if err := tmpl.ExecuteTemplate(w, "diagnostics.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListUSBDevices(t *testing.T) {
+	sys := t.TempDir()
+	write := func(dir, name, value string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(sys, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sys, dir, name), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1-1", "idVendor", "04b8")
+	write("1-1", "idProduct", "0e28")
+	write("1-1", "manufacturer", "EPSON")
+	write("1-1", "product", "TM-T20III")
+	write("1-1:1.0", "bInterfaceClass", "07") // interfaces have no vendor
+	write("usb1", "idVendor", "1d6b")
+
+	devices := listUSBDevices(sys)
+	if len(devices) != 2 {
+		t.Fatalf("listUSBDevices() = %+v, want 2 devices", devices)
+	}
+	expected := usbDevice{Bus: "1-1", VendorID: "04b8", ProductID: "0e28", Manufacturer: "EPSON", Product: "TM-T20III"}
+	if devices[0] != expected {
+		t.Errorf("listUSBDevices()[0] = %+v, want %+v", devices[0], expected)
+	}
+	if devices[1].Bus != "usb1" || devices[1].Product != "" {
+		t.Errorf("listUSBDevices()[1] = %+v", devices[1])
+	}
+
+	if got := listUSBDevices(filepath.Join(sys, "missing")); got != nil {
+		t.Errorf("listUSBDevices() of a missing directory = %+v", got)
+	}
+}
+
+func TestPagesRenderWithoutPrinter(t *testing.T) {
+	var err error
+	tmpl, err = template.ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		t.Fatalf("parsing templates: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	renderPage(w, httptest.NewRequest("GET", "/", nil), "", false)
+	if !strings.Contains(w.Body.String(), "Printer offline") {
+		t.Errorf("printer page does not say the printer is offline")
+	}
+
+	w = httptest.NewRecorder()
+	handleDiagnostics(w, httptest.NewRequest("GET", "/diagnostics", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "GoLabel Diagnostics") {
+		t.Errorf("diagnostics page = %d %q", w.Code, w.Body.String())
+	}
+}
@@ -3,7 +3,9 @@ module github.com/drummonds/golabel
 go 1.24.4
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/mect/go-escpos v0.0.0-20240725094433-67b291810113
+	golang.org/x/crypto v0.39.0
 	golang.org/x/text v0.26.0
 )
 
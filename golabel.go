@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -27,7 +28,22 @@ const maxLineLength = 32
 
 // Command line flags
 var (
-	port = flag.Int("port", 80, "Port to listen on")
+	port         = flag.Int("port", 80, "Port to listen on")
+	tlsMode      = flag.String("tls", "off", "HTTPS: off, files (cert_file and key_file in the config) or self-signed")
+	httpsPort    = flag.Int("https-port", 443, "Port to listen on for HTTPS")
+	tabsFlag     = flag.String("tabs", "4", "Tab stops, either an interval or a comma separated list of columns")
+	fitLines     = flag.Int("fit-lines", 6, "Maximum lines of message when fitting to the label, 0 for no limit")
+	fitLength    = flag.Float64("fit-length", 0, "Maximum length in mm of message when fitting to the label, 0 for no limit")
+	devPath      = flag.String("printer", "", "Printer device path, empty to use the first USB printer found")
+	mediaFlag    = flag.String("media", "continuous", "Paper loaded: continuous, gap or blackmark")
+	labelLen     = flag.Float64("label-length", 0, "Label length in mm for gap and blackmark media")
+	overflow     = flag.String("overflow", "paginate", "Content too long for a label: paginate, shrink or refuse")
+	printerName  = flag.String("printer-name", "tm-t20iii", "Name of the printer in metrics")
+	stateDir     = flag.String("state-dir", "/perm/golabel", "Directory for state kept across restarts, empty to keep none")
+	rollLength   = flag.Float64("roll-length", 80, "Length in metres of a new paper roll")
+	paperWarn    = flag.Float64("paper-warn", 5, "Warn when less than this many metres of paper are left")
+	pulsePin     = flag.Int("pulse-pin", 2, "Drawer kick connector pin used for alerts, 2 or 5")
+	printTimeout = flag.Duration("print-timeout", 30*time.Second, "How long to wait for the printer to confirm a label was printed")
 )
 
 // max function for smart wrapping
@@ -196,13 +212,12 @@ func wrapTextUnicode(text string, maxWidth int) []string {
 	return allLines
 }
 
-// runeWidth returns the display width of a rune
+// runeWidth returns the display width of a rune.  Tabs are expanded to
+// spaces before lines are measured, so a tab has no width of its own.
 func runeWidth(r rune) int {
 	switch {
-	case r == '\t':
-		return 4 // Tab width
-	case r == '\n':
-		return 0 // Newlines don't take width
+	case r == '\t', r == '\n':
+		return 0 // Tabs and newlines don't take width
 	case unicode.IsControl(r):
 		return 0 // Control characters don't take width
 	case width.LookupRune(r).Kind() == width.EastAsianWide:
@@ -212,7 +227,7 @@ func runeWidth(r rune) int {
 	}
 }
 
-// runeWidth returns the display width of a rune
+// runesWidth returns the display width of a run of runes
 func runesWidth(runes []rune) (result int) {
 	for _, thisRune := range runes {
 		result += runeWidth(thisRune)
@@ -220,118 +235,329 @@ func runesWidth(runes []rune) (result int) {
 	return result
 }
 
-// printMessageLines prints a message line by line, wrapping at maxLineLength characters
-func printMessageLines(message string) {
-	message = strings.TrimSpace(message)
-	if message == "" {
-		return
+// labelOptions are the per job choices made when printing
+type labelOptions struct {
+	Fit    bool         // scale the message to fit rather than print at the normal size
+	Alert  alertKind    // pulse the drawer connector after printing
+	Copies int          // copies of each label, 0 meaning one
+	Cut    cutMode      // empty for a full cut
+	CutAt  cutPlacement // empty for a cut between labels
+	Urgent bool         // jump ahead of jobs that are not urgent
+}
+
+// copies returns how many copies of each label to print
+func (o labelOptions) copies() int {
+	return max(o.Copies, 1)
+}
+
+// parseLabelOptions checks the options given in a form or API request and
+// the size of the job.  Empty strings and no copies take the configured
+// defaults.
+func parseLabelOptions(labels int, fit bool, alert string, copies int, cut, cutAt string) (labelOptions, error) {
+	defaults := conf().Defaults
+	opts := labelOptions{Fit: fit, Copies: copies}
+	if copies == 0 {
+		opts.Copies = defaults.Copies
+	}
+	if alert == "" {
+		alert = defaults.Alert
+	}
+	if cut == "" {
+		cut = defaults.Cut
+	}
+	if cutAt == "" {
+		cutAt = defaults.CutAt
+	}
+	if err := checkJobSize(labels, opts.Copies); err != nil {
+		return opts, err
+	}
+	var err error
+	if opts.Alert, err = parseAlert(alert); err != nil {
+		return opts, err
+	}
+	if opts.Cut, err = parseCutMode(cut); err != nil {
+		return opts, err
 	}
+	opts.CutAt, err = parseCutPlacement(cutAt)
+	return opts, err
+}
 
-	// Split by line breaks and process each line
-	lines := strings.Split(message, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			p.PrintLn("") // Print blank line for empty lines
-			continue
-		}
+// labelResult describes a printed job
+type labelResult struct {
+	Printed time.Time // when the printer confirmed it was printed
+	Lines   int       // message lines printed
+	PaperMM float64   // length of paper used
+	Cuts    int
+}
+
+// laidOutLabel is a label ready to send to the printer
+type laidOutLabel struct {
+	size    int
+	pages   [][]string
+	barcode int
+}
 
-		// Use the Unicode-aware wrapping function
-		wrappedLines := wrapTextUnicode(line, maxLineLength)
-		for _, wrappedLine := range wrappedLines {
-			p.PrintLn(wrappedLine)
+// printLabels prints a batch of task labels, with copies and cuts as opts
+// asks, and returns when the printer confirmed they were printed
+func printLabels(labels []labelSpec, opts labelOptions) (labelResult, error) {
+	var result labelResult
+	c := conf()
+
+	// Lay everything out first so a bad label fails the job before any
+	// paper is used
+	laid := make([]laidOutLabel, 0, len(labels))
+	for _, l := range labels {
+		// Sanitize message to prevent injection
+		message := strings.TrimSpace(stripControl(l.Message))
+		if message == "" {
+			return result, permanentError{fmt.Errorf("message cannot be empty")}
+		}
+		size, pages, err := c.media.layoutLabels(message, opts.Fit)
+		if err != nil {
+			return result, permanentError{err}
 		}
+		laid = append(laid, laidOutLabel{size: size, pages: pages, barcode: l.Barcode})
+	}
+
+	printMu.Lock()
+	defer printMu.Unlock()
+
+	if !printerConnected() {
+		return result, errDisconnected
+	}
+	status := pollStatus()
+	if !status.Ready() {
+		return result, fmt.Errorf("printer not ready: %s", status.Summary())
 	}
-}
 
-func label(message string, num int) error {
-	if p == nil {
-		return fmt.Errorf("printer not initialized")
+	// Until the printer confirms a label was printed a failure is retried,
+	// as likely nothing came out.  After that some labels may have printed.
+	confirmed := false
+	fail := func(err error) (labelResult, error) {
+		p.End()
+		if !printerConnected() && !errors.Is(err, errDisconnected) {
+			err = fmt.Errorf("%w: %w", errDisconnected, err)
+		}
+		if confirmed {
+			return result, mayHavePrinted(err)
+		}
+		return result, err
 	}
 
 	p.Init()       // start
 	p.Smooth(true) // use smooth printing
-	p.Size(3, 3)   // set font size
-	p.Underline(true)
-	p.Align(escpos.AlignCenter)
-	p.PrintLn("Task")
-	p.Underline(false)
+	total := len(laid) * opts.copies()
+	printed := 0
+	for _, l := range laid {
+		for range opts.copies() {
+			if err := printLabel(c, l); err != nil {
+				return fail(err)
+			}
+			printed++
+			for _, page := range l.pages {
+				result.Lines += len(page)
+			}
+			result.PaperMM += c.media.paperUsedMM(l.pages, l.size)
 
-	p.Size(2, 2)
-	p.Font(escpos.FontB) // change font
-	p.Align(escpos.AlignLeft)
+			if printed == total || opts.CutAt != cutAtEnd {
+				cuts, _ := cut(opts.Cut)
+				result.Cuts += cuts
+			}
+			// confirm the first label before sending the rest
+			if printed == 1 && total > 1 {
+				if _, err := confirmPrinted(c.Printer.PrintTimeout.Duration); err != nil {
+					return fail(err)
+				}
+				confirmed = true
+			}
+		}
+	}
+	sendAlert(opts.Alert)
+	p.End() // stop
 
-	// Sanitize message to prevent injection
-	message = strings.TrimSpace(message)
-	if message == "" {
-		return fmt.Errorf("message cannot be empty")
+	var err error
+	result.Printed, err = confirmPrinted(c.Printer.PrintTimeout.Duration)
+	if err != nil {
+		return fail(err)
 	}
+	return result, nil
+}
 
-	printMessageLines(message)
+// printLabel sends one task label to the printer.  It fails if the printer
+// could not be moved on to the next label, which would leave the rest of the
+// job out of line with the labels.  The caller must hold printMu.
+func printLabel(c *config, l laidOutLabel) error {
+	for i, lines := range l.pages {
+		if i == 0 && c.Template.Heading != "" {
+			p.Size(3, 3) // set font size
+			p.Font(escpos.FontA)
+			p.Underline(true)
+			p.Align(escpos.AlignCenter)
+			printLine(c, visualOrder(c.Template.Heading, textLevel(c.Template.Heading)))
+			p.Underline(false)
+		}
 
-	p.Feed(2)
+		p.Size(uint8(l.size), uint8(l.size))
+		p.Font(escpos.FontB) // change font
+		p.Align(escpos.AlignLeft)
+		for _, line := range lines {
+			printLine(c, line)
+		}
 
-	p.Align(escpos.AlignCenter)
-	p.Barcode(fmt.Sprintf("%d", num), escpos.BarcodeTypeCODE39) // print barcode
-	p.Align(escpos.AlignLeft)
-	p.Size(1, 1) // set font size
-	p.PrintLn("Printed at: " + time.Now().Format("2006-01-02T15:04:05Z"))
+		if i == len(l.pages)-1 {
+			p.Feed(labelFeedLines)
 
-	p.Cut() // cut
-	p.End() // stop
-	time.Sleep(time.Second * 1)
+			p.Align(escpos.AlignCenter)
+			p.Barcode(fmt.Sprintf("%d", l.barcode), escpos.BarcodeTypeCODE39) // print barcode
+			p.Align(escpos.AlignLeft)
+			p.Size(1, 1) // set font size
+			p.PrintLn("Printed at: " + time.Now().Format(c.Template.TimestampFormat))
+		}
+		if err := c.media.endLabel(); err != nil {
+			return fmt.Errorf("feeding to the next label: %w", err)
+		}
+	}
 	return nil
 }
 
+// printJob prints a job from the queue
+func printJob(j *job) (time.Time, error) {
+	start := time.Now()
+	result, err := printLabels(j.Labels, j.Options)
+	// a failure is counted once, when the job has no attempts left
+	if !conf().Retry.retries(j.Attempts+1, err) {
+		metrics.jobFinished(j, result, time.Since(start), err)
+	}
+	if err == nil {
+		limits.usedPaper(j.From, j.PaperMM, result.PaperMM, time.Now())
+		if perr := paper.record(result.PaperMM, time.Now()); perr != nil {
+			fmt.Println("Error saving paper usage:", perr)
+		}
+	}
+	return result.Printed, err
+}
+
 type PageData struct {
 	Status    string
 	Success   bool
 	Version   string
 	BuildDate string
+	Printer   printerStatus
+	Queued    int
+	Paper     paperReport
+	Defaults  defaultsConfig
+	User      string // logged in user, empty when auth is off
+	CSRF      string
+	Role      role
+	Printers  []string // printers and templates the role may print with
+	Templates []string
+	Jobs      []job       // past jobs the role may reprint
+	Waiting   []queuedJob // jobs in the queue
+	Failed    []failedJob // jobs that failed for good
+	Paused    bool
+	Reload    bool // there is a config file to reload
 }
 
 func handlePrint(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
-		message := r.FormValue("message")
-		barcodeStr := r.FormValue("barcode")
-
-		if message == "" {
-			renderPage(w, "Error: Message cannot be empty", false)
+		from := requesterFrom(r)
+		if err := conf().checkPrint(from, r.FormValue("printer"), r.FormValue("template")); err != nil {
+			renderPage(w, r, "Error: "+err.Error(), false)
 			return
 		}
+		message, err := cleanMessage(r.FormValue("message"))
+		if err != nil {
+			renderPage(w, r, "Error: "+err.Error(), false)
+			return
+		}
+		barcodeStr := r.FormValue("barcode")
 
 		barcode, err := strconv.Atoi(barcodeStr)
 		if err != nil {
-			barcode = 5 // default value
+			barcode = conf().Defaults.Barcode
+		}
+		// An optional last barcode prints a run of labels, one per number
+		lastBarcode, _ := strconv.Atoi(r.FormValue("last_barcode"))
+		labels, err := barcodeRun(message, barcode, lastBarcode)
+		if err != nil {
+			renderPage(w, r, "Error: "+err.Error(), false)
+			return
 		}
 
-		// Call the label function
-		err = label(message, barcode)
+		copies, _ := strconv.Atoi(r.FormValue("copies"))
+		opts, err := parseLabelOptions(len(labels), r.FormValue("fit") != "", r.FormValue("alert"),
+			copies, r.FormValue("cut"), r.FormValue("cut_at"))
 		if err != nil {
-			renderPage(w, err.Error(), false)
+			renderPage(w, r, "Error: "+err.Error(), false)
+			return
+		}
+		if r.FormValue("urgent") != "" {
+			if err := checkUrgent(conf(), from); err != nil {
+				renderPage(w, r, "Error: "+err.Error(), false)
+				return
+			}
+			opts.Urgent = true
+		}
+
+		if err := limits.admitJob(from, labels, opts, time.Now()); err != nil {
+			renderPage(w, r, err.Error(), false)
+			return
+		}
+
+		// Queue the label and wait to see how it went
+		j := queue.submit(from, labels, opts)
+		auditJob(r, auditPrint, j, "")
+		if !queue.wait(j, conf().Printer.PrintTimeout.Duration+5*time.Second) {
+			if result := queue.jobResult(j); result.State == jobRetrying {
+				renderPage(w, r, fmt.Sprintf("Job %d failed and will be tried again at %s: %s", j.ID, result.NextTry.Format("15:04:05"), result.Err), false)
+				return
+			}
+			renderPage(w, r, fmt.Sprintf("Label queued as job %d, waiting for the printer", j.ID), true)
+			return
+		}
+		result := queue.jobResult(j)
+		if result.State == jobFailed {
+			renderPage(w, r, result.Err, false)
 			return
 		}
 
-		renderPage(w, "Label printed successfully!", true)
+		renderPage(w, r, "Label printed successfully at "+result.Printed.Format("15:04:05")+"!", true)
 	} else {
-		renderPage(w, "", false)
+		renderPage(w, r, "", false)
 	}
 }
 
-func renderPage(w http.ResponseWriter, status string, success bool) {
+func renderPage(w http.ResponseWriter, r *http.Request, status string, success bool) {
 	if tmpl == nil {
 		http.Error(w, "Template not initialized", http.StatusInternalServerError)
 		return
 	}
 
+	c := conf()
+	from := requesterFrom(r)
+	userRole := c.roleOf(from)
 	data := PageData{
 		Status:    status,
 		Success:   success,
 		Version:   version.GetVersion(),
 		BuildDate: version.GetBuildDate(),
+		Printer:   checkStatus(),
+		Queued:    queue.depth(),
+		Defaults:  c.Defaults,
+		User:      from.User,
+		CSRF:      csrfToken(w, r),
+		Role:      userRole,
+		Printers:  userRole.printers(c),
+		Templates: userRole.templates(c),
+		Jobs:      reprintable(c, from),
+		Waiting:   waitingJobs(c, from),
+		Failed:    failedJobs(c, from),
+		Paused:    queue.isPaused(),
+		Reload:    *configPath != "",
 	}
+	data.Paper = paper.report(time.Now(), c.Printer.PaperWarn*1000, data.Printer.PaperNearEnd)
 
-	err := tmpl.Execute(w, data)
+	err := tmpl.ExecuteTemplate(w, "printer.html", data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -341,29 +567,99 @@ func main() {
 	// Parse command line flags
 	flag.Parse()
 
-	var err error
+	c, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Println("Error in settings:", err)
+		return
+	}
+	currentConfig.Store(c)
 
-	// Initialize template from embedded filesystem
-	tmpl, err = template.ParseFS(templateFS, "templates/printer.html")
+	// Initialize templates from embedded filesystem
+	tmpl, err = template.ParseFS(templateFS, "templates/*.html")
 	if err != nil {
 		fmt.Println("Error parsing template:", err)
 		return
 	}
 
-	p, err = escpos.NewUSBPrinterByPath("") // empty string will do a self discovery
+	paper, err = loadPaperLedger(c.Server.StateDir, c.Printer.Name, c.Printer.RollLength*1000)
 	if err != nil {
-		fmt.Println("Error initializing printer:", err)
-		return
+		fmt.Println("Error loading paper usage, starting afresh:", err)
+	}
+	schedules, err = loadSchedules(c.Server.StateDir)
+	if err != nil {
+		fmt.Println("Error loading schedules, starting with none:", err)
+	}
+	spool, err := openSpool(c.Server.StateDir)
+	if err != nil {
+		fmt.Println("Error opening the job spool, jobs will not survive a restart:", err)
+	} else if spool != nil {
+		if err := queue.restore(spool, time.Now()); err != nil {
+			fmt.Println("Error reading the job spool:", err)
+		}
+		if n := queue.depth(); n > 0 {
+			fmt.Printf("Resuming %d spooled job(s)\n", n)
+		}
 	}
 
-	fmt.Printf("Starting GoLabel web server on http://localhost:%d\n", *port)
+	if c.Server.TLS == tlsOff {
+		fmt.Printf("Starting GoLabel web server on http://localhost:%d\n", c.Server.Port)
+	} else {
+		fmt.Printf("Starting GoLabel web server on https://localhost:%d\n", c.Server.HTTPSPort)
+	}
 	fmt.Printf("Version: %s\n", version.GetVersionInfo())
-	fmt.Println("Printer initialized successfully")
 
-	http.HandleFunc("/", handlePrint)
-	http.HandleFunc("/print", handlePrint)
+	// Without a printer the web server still starts so jobs can be queued
+	// and the diagnostics page shows what is wrong
+	printMu.Lock()
+	err = openPrinter(c.Printer.Device) // empty path will do a self discovery
+	printMu.Unlock()
+	if err != nil {
+		fmt.Println("Printer offline, jobs will be queued:", err)
+	} else {
+		fmt.Println("Printer initialized successfully")
+	}
+
+	go queue.run(printJob)
+	go supervisePrinter(queue)
+	go runSchedules(schedules)
+	if *configPath != "" {
+		go watchConfig(*configPath)
+	}
 
-	err = http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
+	http.HandleFunc("/", requireLogin(checkForm(handlePrint)))
+	http.HandleFunc("/print", requireLogin(checkForm(handlePrint)))
+	http.HandleFunc("/login", checkForm(handleLogin))
+	http.HandleFunc("/logout", checkForm(handleLogout))
+	http.HandleFunc("/api/status", requireAPIUser(handleStatus))
+	http.HandleFunc("/api/print", requireAPIUser(checkAPI(handleAPIPrint)))
+	http.HandleFunc("/diagnostics", requireLogin(handleDiagnostics))
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("/debug/status", requireAPIUser(handleDebugStatus))
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/paper/roll", requireLogin(checkForm(requirePermission(permConfigure, handleRollChange))))
+	http.HandleFunc("/reprint", requireLogin(checkForm(handleReprint)))
+	http.HandleFunc("/api/reprint", requireAPIUser(checkAPI(handleAPIReprint)))
+	http.HandleFunc("/cancel", requireLogin(checkForm(handleCancel)))
+	http.HandleFunc("/urgent", requireLogin(checkForm(handleUrgent)))
+	http.HandleFunc("/pause", requireLogin(checkForm(handlePause(true))))
+	http.HandleFunc("/resume", requireLogin(checkForm(handlePause(false))))
+	http.HandleFunc("/api/queue", requireAPIUser(handleAPIQueue))
+	http.HandleFunc("/api/cancel", requireAPIUser(checkAPI(handleAPIJob(auditCancel, cancelJob))))
+	http.HandleFunc("/api/urgent", requireAPIUser(checkAPI(handleAPIJob(auditUrgent, urgentJob))))
+	http.HandleFunc("/resubmit", requireLogin(checkForm(handleResubmit)))
+	http.HandleFunc("/dismiss", requireLogin(checkForm(handleDismiss)))
+	http.HandleFunc("/api/failed", requireAPIUser(handleAPIFailed))
+	http.HandleFunc("/api/resubmit", requireAPIUser(checkAPI(handleAPIResubmit)))
+	http.HandleFunc("/api/dismiss", requireAPIUser(checkAPI(handleAPIJob(auditDismiss, dismissFailed))))
+	http.HandleFunc("/api/pause", requireAPIUser(checkAPI(handleAPIPause(true))))
+	http.HandleFunc("/api/resume", requireAPIUser(checkAPI(handleAPIPause(false))))
+	http.HandleFunc("/schedules", requireLogin(checkForm(requirePermission(permSchedule, handleSchedules))))
+	http.HandleFunc("/schedules/change", requireLogin(checkForm(requirePermission(permSchedule, handleScheduleChange))))
+	http.HandleFunc("/audit", requireLogin(requirePermission(permAudit, handleAudit)))
+	http.HandleFunc("/config/reload", requireLogin(checkForm(requirePermission(permConfigure, handleReload))))
+
+	err = serve(c.Server, http.DefaultServeMux)
 	if err != nil {
 		fmt.Println("Error starting server:", err)
 	}
@@ -157,7 +157,7 @@ func TestRuneWidth(t *testing.T) {
 		r        rune
 		expected int
 	}{
-		{"Tab character", '\t', 4},
+		{"Tab character", '\t', 0},
 		{"Newline character", '\n', 0},
 		{"Control character", '\x00', 0},
 		{"ASCII character", 'A', 1},
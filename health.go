@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/drummonds/golabel/version"
+)
+
+// handleHealthz reports that the process is up
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the printer is reachable and not in error
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := checkStatus()
+	if !status.Ready() {
+		http.Error(w, "not ready: "+status.Summary(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
+
+// jobSummary is a job as reported by the API and /debug/status, without the
+// message
+type jobSummary struct {
+	ID        int       `json:"id"`
+	User      string    `json:"user,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	State     jobState  `json:"state"`
+	Urgent    bool      `json:"urgent,omitempty"`
+	Attempts  int       `json:"attempts,omitempty"`
+	NextTry   time.Time `json:"nextTry,omitzero"`
+	Submitted time.Time `json:"submitted"`
+	Printed   time.Time `json:"printed,omitzero"`
+	Finished  time.Time `json:"finished"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// errorSummary is an error and when it happened
+type errorSummary struct {
+	Message string    `json:"message"`
+	When    time.Time `json:"when"`
+}
+
+// debugStatus is the JSON returned by /debug/status
+type debugStatus struct {
+	Version    string        `json:"version"`
+	GitCommit  string        `json:"gitCommit"`
+	BuildDate  string        `json:"buildDate"`
+	Backend    string        `json:"backend"`
+	Device     string        `json:"device"`
+	Ready      bool          `json:"ready"`
+	Summary    string        `json:"summary"`
+	Printer    printerStatus `json:"printer"`
+	QueueDepth int           `json:"queueDepth"`
+	LastJob    *jobSummary   `json:"lastJob,omitempty"`
+	LastError  *errorSummary `json:"lastError,omitempty"`
+}
+
+// lastFinished returns the most recently finished job, if any
+func (q *jobQueue) lastFinished() (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.recent) == 0 {
+		return job{}, false
+	}
+	return *q.recent[len(q.recent)-1], true
+}
+
+// lastFailure returns the most recent job error, if any
+func (q *jobQueue) lastFailure() (errorSummary, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := len(q.recent) - 1; i >= 0; i-- {
+		if j := q.recent[i]; j.State == jobFailed {
+			return errorSummary{Message: j.Err, When: j.Finished}, true
+		}
+	}
+	return errorSummary{}, false
+}
+
+// handleDebugStatus returns the state of golabel as JSON for monitoring
+func handleDebugStatus(w http.ResponseWriter, r *http.Request) {
+	status := checkStatus()
+	data := debugStatus{
+		Version:    version.GetVersion(),
+		GitCommit:  version.GitCommit,
+		BuildDate:  version.GetBuildDate(),
+		Backend:    "usb",
+		Ready:      status.Ready(),
+		Summary:    status.Summary(),
+		Printer:    status,
+		QueueDepth: queue.depth(),
+	}
+
+	if j, ok := queue.lastFinished(); ok {
+		summary := summarizeJob(j)
+		data.LastJob = &summary
+	}
+	if failure, ok := queue.lastFailure(); ok {
+		data.LastError = &failure
+	}
+
+	var openErr errorSummary
+	data.Device, openErr = deviceState()
+	if openErr.Message != "" && (data.LastError == nil || openErr.When.After(data.LastError.When)) {
+		data.LastError = &openErr
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != 200 {
+		t.Errorf("/healthz = %d, want 200", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != 503 {
+		t.Errorf("/readyz without a printer = %d, want 503", w.Code)
+	}
+
+	useFakePrinter(t)
+	w = httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != 200 {
+		t.Errorf("/readyz with a printer = %d %q, want 200", w.Code, w.Body.String())
+	}
+}
+
+func TestDebugStatus(t *testing.T) {
+	useConfigWith(t, func(c *config) { c.Retry.MaxAttempts = 1 })
+	saved := queue
+	queue = newJobQueue()
+	t.Cleanup(func() { queue = saved })
+	go queue.run(func(j *job) (time.Time, error) {
+		if j.ID == 1 {
+			return time.Time{}, errors.New("cutter error")
+		}
+		return time.Now(), nil
+	})
+	queue.submit(requester{}, []labelSpec{{"one", 1}}, labelOptions{})
+	queue.wait(queue.submit(requester{}, []labelSpec{{"two", 2}}, labelOptions{}), time.Second)
+
+	w := httptest.NewRecorder()
+	handleDebugStatus(w, httptest.NewRequest("GET", "/debug/status", nil))
+	var got debugStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("/debug/status returned bad JSON: %v", err)
+	}
+	if got.Version == "" || got.Backend != "usb" || got.QueueDepth != 0 {
+		t.Errorf("/debug/status = %+v", got)
+	}
+	if got.LastJob == nil || got.LastJob.ID != 2 || got.LastJob.State != jobPrinted {
+		t.Errorf("/debug/status last job = %+v, want job 2 printed", got.LastJob)
+	}
+	if got.LastError == nil || got.LastError.Message != "cutter error" {
+		t.Errorf("/debug/status last error = %+v, want cutter error", got.LastError)
+	}
+}
+
+func TestMonitoringDoesNotWaitForPrinting(t *testing.T) {
+	tmpl = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+	printMu.Lock() // a job is printing
+	defer printMu.Unlock()
+	for path, handler := range map[string]http.HandlerFunc{
+		"/debug/status": handleDebugStatus,
+		"/diagnostics":  handleDiagnostics,
+	} {
+		done := make(chan struct{})
+		go func() {
+			handler(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("%s waited for the job printing", path)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// historyShown is how many past jobs the web page lists
+const historyShown = 10
+
+// errNoJob is returned for a job that is not in the history
+var errNoJob = errors.New("no such job in the history")
+
+// canReprint reports whether someone with role r may reprint a past job:
+// their own with reprint, anyone's with manage_jobs
+func canReprint(r role, from requester, j *job) bool {
+	return r.Can(permManage) || (r.Can(permReprint) && from.owns(j))
+}
+
+// reprintable returns the past jobs from may reprint, newest first
+func reprintable(c *config, from requester) []job {
+	r := c.roleOf(from)
+	return queue.history(historyShown, func(j *job) bool {
+		return canReprint(r, from, j)
+	})
+}
+
+// reprint queues a job from the history again, as printed by from
+func reprint(from requester, id int) (*job, error) {
+	c := conf()
+	old, ok := queue.find(id)
+	if !ok {
+		return nil, errNoJob
+	}
+	if !canReprint(c.roleOf(from), from, &old) {
+		return nil, forbiddenError(fmt.Sprintf("your role cannot reprint job %d", id))
+	}
+	if err := c.checkPrint(from, "", old.Template); err != nil {
+		return nil, err
+	}
+	if err := limits.admitJob(from, old.Labels, old.Options, time.Now()); err != nil {
+		return nil, err
+	}
+	opts := old.Options
+	opts.Urgent = false
+	return queue.submit(from, old.Labels, opts), nil
+}
+
+// handleReprint reprints a job from the history list on the web page
+func handleReprint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	j, err := reprint(requesterFrom(r), id)
+	if err != nil {
+		renderPage(w, r, "Error: "+err.Error(), false)
+		return
+	}
+	auditJob(r, auditReprint, j, fmt.Sprintf("reprint of job %d", id))
+	renderPage(w, r, fmt.Sprintf("Job %d queued again as job %d", id, j.ID), true)
+}
+
+// apiJobRequest is the JSON body of POST /api/reprint, /api/cancel,
+// /api/urgent, /api/resubmit and /api/dismiss
+type apiJobRequest struct {
+	ID int `json:"id"`
+}
+
+// handleAPIReprint reprints a job from the history, answering like
+// /api/print
+func handleAPIReprint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "POST only")
+		return
+	}
+	var req apiJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	j, err := reprint(requesterFrom(r), req.ID)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	auditJob(r, auditReprint, j, fmt.Sprintf("reprint of job %d", req.ID))
+	writeJobResult(w, j)
+}
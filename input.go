@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxBarcode is the largest barcode number, as allowed by the form
+const maxBarcode = 999999
+
+// stripControl removes control characters from a message, which could
+// otherwise carry raw ESC/POS commands to the printer.  Newlines and tabs
+// are kept and carriage returns dropped.
+func stripControl(message string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, message)
+}
+
+// cleanMessage strips control characters from a message and checks it
+// against the configured length and line limits
+func cleanMessage(message string) (string, error) {
+	message = strings.TrimSpace(stripControl(message))
+	security := conf().Security
+	if message == "" {
+		return "", fmt.Errorf("message cannot be empty")
+	}
+	if n := utf8.RuneCountInString(message); n > security.MaxMessageLength {
+		return "", fmt.Errorf("message is %d characters, the most is %d", n, security.MaxMessageLength)
+	}
+	if n := strings.Count(message, "\n") + 1; n > security.MaxMessageLines {
+		return "", fmt.Errorf("message is %d lines, the most is %d", n, security.MaxMessageLines)
+	}
+	if err := checkPrintable(message, conf().kanji); err != nil {
+		return "", fmt.Errorf("message %w", err)
+	}
+	return message, nil
+}
+
+// maxUnprintable is how many characters the printer cannot print are named
+// in an error
+const maxUnprintable = 5
+
+// checkPrintable checks that one of the printer's code pages, or its Kanji
+// character set, has every character of s, as a character none has cannot
+// be printed
+func checkPrintable(s string, kanji *kanjiCharset) error {
+	var bad []string
+	for _, r := range s {
+		if r == '\n' || r == '\t' {
+			continue
+		}
+		if !kanji.printable(r) && !slices.Contains(bad, string(r)) {
+			bad = append(bad, string(r))
+		}
+	}
+	switch {
+	case len(bad) == 0:
+		return nil
+	case len(bad) > maxUnprintable:
+		return fmt.Errorf("has %d characters the printer cannot print, such as %s", len(bad), strings.Join(bad[:maxUnprintable], " "))
+	}
+	return fmt.Errorf("has characters the printer cannot print: %s", strings.Join(bad, " "))
+}
+
+// checkBarcode checks a barcode number is in range
+func checkBarcode(n int) error {
+	if n < 1 || n > maxBarcode {
+		return fmt.Errorf("barcode must be between 1 and %d", maxBarcode)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripControl(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Plain", "Buy milk", "Buy milk"},
+		{"Newlines and tabs", "a\tb\nc", "a\tb\nc"},
+		{"Carriage returns", "a\r\nb", "a\nb"},
+		{"Cut command", "Hi\x1dVA0there", "HiVA0there"},
+		{"Drawer kick", "\x1bp\x00\x32\x64ok", "p2dok"},
+		{"C1 control", "a\u0085b", "ab"},
+		{"Invalid UTF-8", "a\xffb", "ab"},
+		{"Unicode kept", "café 日本", "café 日本"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := stripControl(tt.input); result != tt.expected {
+				t.Errorf("stripControl(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCleanMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{"Trimmed", "  Milk \n", "Milk", false},
+		{"Only control", "\x1b\x1d", "", true},
+		{"Too long", strings.Repeat("x", 2001), "", true},
+		{"Longest", strings.Repeat("x", 2000), strings.Repeat("x", 2000), false},
+		{"Too many lines", strings.Repeat("x\n", 50) + "x", "", true},
+		{"Latin and euro", "Crème brûlée €5", "Crème brûlée €5", false},
+		{"Hebrew", "חלב", "חלב", false},
+		{"Arabic", "Milk حليب", "Milk حليب", false},
+		{"Thai", "นม", "", true},
+		{"Kanji without a Kanji mode", "| 部品 | 4 |", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := cleanMessage(tt.input)
+			if (err != nil) != tt.wantErr || result != tt.expected {
+				t.Errorf("cleanMessage() = %q, %v, wantErr %v", result, err, tt.wantErr)
+			}
+		})
+	}
+
+	useConfigWith(t, func(c *config) { c.Printer.Kanji = "shift_jis" })
+	if result, err := cleanMessage("| 部品 | 4 |"); err != nil || result != "| 部品 | 4 |" {
+		t.Errorf("cleanMessage() of Kanji with a Kanji mode = %q, %v", result, err)
+	}
+}
+
+func TestCheckBarcode(t *testing.T) {
+	for n, ok := range map[int]bool{0: false, 1: true, maxBarcode: true, maxBarcode + 1: false, -5: false} {
+		if err := checkBarcode(n); (err == nil) != ok {
+			t.Errorf("checkBarcode(%d) error = %v", n, err)
+		}
+	}
+}
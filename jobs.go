@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// queuedJob is a job waiting on the web page, with what the viewer may do
+// to it
+type queuedJob struct {
+	job
+	CanCancel bool
+}
+
+// apiQueue is the JSON returned by GET /api/queue
+type apiQueue struct {
+	Paused bool         `json:"paused"`
+	Held   bool         `json:"held"` // waiting for the printer to reconnect
+	Jobs   []jobSummary `json:"jobs"`
+}
+
+// canCancel reports whether someone with role r may cancel a queued job:
+// their own, or anyone's with manage_jobs
+func canCancel(r role, from requester, j *job) bool {
+	return r.Can(permManage) || from.owns(j)
+}
+
+// checkUrgent checks that from may mark a job urgent, which takes
+// manage_jobs as it puts the job ahead of other people's
+func checkUrgent(c *config, from requester) error {
+	if !c.roleOf(from).Can(permManage) {
+		return forbiddenError("your role cannot make jobs urgent")
+	}
+	return nil
+}
+
+// waitingJobs returns the jobs in the queue as from sees them
+func waitingJobs(c *config, from requester) []queuedJob {
+	r := c.roleOf(from)
+	var jobs []queuedJob
+	for _, j := range queue.queued() {
+		jobs = append(jobs, queuedJob{job: j, CanCancel: j.State != jobPrinting && canCancel(r, from, &j)})
+	}
+	return jobs
+}
+
+// cancelJob takes a job that has not started printing out of the queue
+func cancelJob(from requester, id int) (job, error) {
+	r := conf().roleOf(from)
+	return queue.cancel(id, func(j *job) error {
+		if !canCancel(r, from, j) {
+			return forbiddenError(fmt.Sprintf("your role cannot cancel job %d", id))
+		}
+		return nil
+	})
+}
+
+// urgentJob moves a job that has not started printing up the queue
+func urgentJob(from requester, id int) (job, error) {
+	if err := checkUrgent(conf(), from); err != nil {
+		return job{}, err
+	}
+	return queue.promote(id)
+}
+
+// pausePrinting stops or restarts printing, as when changing the roll
+func pausePrinting(from requester, paused bool) error {
+	if !conf().roleOf(from).Can(permConfigure) {
+		return forbiddenError("your role cannot pause the printer")
+	}
+	queue.setPaused(paused)
+	return nil
+}
+
+// pauseAction is the audit action for pausing or resuming
+func pauseAction(paused bool) string {
+	if paused {
+		return auditPause
+	}
+	return auditResume
+}
+
+// handleCancel cancels a queued job from the web page
+func handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	j, err := cancelJob(requesterFrom(r), id)
+	if err != nil {
+		renderPage(w, r, "Error: "+err.Error(), false)
+		return
+	}
+	auditJob(r, auditCancel, &j, "")
+	renderPage(w, r, fmt.Sprintf("Job %d cancelled", id), true)
+}
+
+// handleUrgent moves a queued job up from the web page
+func handleUrgent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	j, err := urgentJob(requesterFrom(r), id)
+	if err != nil {
+		renderPage(w, r, "Error: "+err.Error(), false)
+		return
+	}
+	auditJob(r, auditUrgent, &j, "")
+	renderPage(w, r, fmt.Sprintf("Job %d marked urgent", id), true)
+}
+
+// handlePause pauses or resumes printing from the web page
+func handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := pausePrinting(requesterFrom(r), paused); err != nil {
+			renderPage(w, r, "Error: "+err.Error(), false)
+			return
+		}
+		audit.record(newAuditEvent(r, pauseAction(paused)))
+		if paused {
+			renderPage(w, r, "Printing paused, jobs will wait until it is resumed", true)
+			return
+		}
+		renderPage(w, r, "Printing resumed", true)
+	}
+}
+
+// handleAPIQueue lists the jobs waiting or printing
+func handleAPIQueue(w http.ResponseWriter, r *http.Request) {
+	data := apiQueue{Paused: queue.isPaused(), Held: queue.isHeld(), Jobs: []jobSummary{}}
+	for _, j := range queue.queued() {
+		data.Jobs = append(data.Jobs, summarizeJob(j))
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+// handleAPIJob runs action on the job in the request body, answering with
+// the job
+func handleAPIJob(action string, run func(requester, int) (job, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "POST only")
+			return
+		}
+		var req apiJobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		j, err := run(requesterFrom(r), req.ID)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		auditJob(r, action, &j, "")
+		writeJSON(w, http.StatusOK, summarizeJob(j))
+	}
+}
+
+// handleAPIPause pauses or resumes printing
+func handleAPIPause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "POST only")
+			return
+		}
+		if err := pausePrinting(requesterFrom(r), paused); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		audit.record(newAuditEvent(r, pauseAction(paused)))
+		handleAPIQueue(w, r)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobControlPermissions(t *testing.T) {
+	useRoles(t, testRoles)
+	useAudit(t)
+	q := useTestQueue(t, func(j *job) (time.Time, error) { return time.Now(), nil })
+	q.setPaused(true)
+	alices := q.submit(requester{User: "alice"}, []labelSpec{{"Milk", 7}}, labelOptions{})
+	bobs := q.submit(requester{User: "bob"}, []labelSpec{{"Eggs", 8}}, labelOptions{})
+
+	cancel := handleAPIJob(auditCancel, cancelJob)
+	urgent := handleAPIJob(auditUrgent, urgentJob)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		user    string
+		id      int
+		code    int
+	}{
+		{"Cancel someone else's job", cancel, "alice", bobs.ID, 403},
+		{"Make urgent without manage_jobs", urgent, "alice", alices.ID, 403},
+		{"Make urgent", urgent, "boss", bobs.ID, 200},
+		{"Cancel own job", cancel, "alice", alices.ID, 200},
+		{"Cancel a cancelled job", cancel, "alice", alices.ID, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, jobRequest(tt.user, tt.id))
+			if w.Code != tt.code {
+				t.Errorf("%s as %s = %d %s, want %d", tt.name, tt.user, w.Code, w.Body.String(), tt.code)
+			}
+		})
+	}
+
+	// pausing takes configure
+	w := httptest.NewRecorder()
+	handleAPIPause(false)(w, withUser(httptest.NewRequest("POST", "/api/resume", nil), "alice"))
+	if w.Code != 403 || !q.isPaused() {
+		t.Errorf("user resume = %d, paused %v, want 403 and still paused", w.Code, q.isPaused())
+	}
+	w = httptest.NewRecorder()
+	handleAPIQueue(w, httptest.NewRequest("GET", "/api/queue", nil))
+	var data apiQueue
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if !data.Paused || len(data.Jobs) != 1 || data.Jobs[0].ID != bobs.ID || !data.Jobs[0].Urgent {
+		t.Errorf("GET /api/queue = %+v, want bob's urgent job, paused", data)
+	}
+	tmpl = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+	w = httptest.NewRecorder()
+	renderPage(w, withUser(httptest.NewRequest("GET", "/", nil), "boss"), "", false)
+	if body := w.Body.String(); !strings.Contains(body, `action="/cancel"`) || !strings.Contains(body, "Resume printing") {
+		t.Errorf("page for an admin does not offer to cancel and resume")
+	}
+	w = httptest.NewRecorder()
+	renderPage(w, withUser(httptest.NewRequest("GET", "/", nil), "alice"), "", false)
+	if body := w.Body.String(); strings.Contains(body, `action="/cancel"`) || strings.Contains(body, "Resume printing") {
+		t.Errorf("page offers alice to cancel bob's job or resume printing")
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIPause(false)(w, withUser(httptest.NewRequest("POST", "/api/resume", nil), "boss"))
+	if w.Code != 200 || !q.wait(bobs, time.Second) {
+		t.Errorf("admin resume = %d, want bob's job printed", w.Code)
+	}
+
+	// urgent jobs through the print API also take manage_jobs
+	w = httptest.NewRecorder()
+	handleAPIPrint(w, withUser(httptest.NewRequest("POST", "/api/print", strings.NewReader(`{"message":"Now","urgent":true}`)), "alice"))
+	if w.Code != 403 {
+		t.Errorf("user urgent print = %d, want 403", w.Code)
+	}
+
+	events, _ := audit.read(conf().Audit.File, 1, auditFilter{Action: auditCancel}, 10)
+	if len(events) != 1 || events[0].Job != alices.ID || events[0].User != "alice" {
+		t.Errorf("audited cancels = %+v, want alice's", events)
+	}
+}
+
+// jobRequest is a POST to a job endpoint by user
+func jobRequest(user string, id int) *http.Request {
+	body := strings.NewReader(fmt.Sprintf(`{"id":%d}`, id))
+	return withUser(httptest.NewRequest("POST", "/api/job", body), user)
+}
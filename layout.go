@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mect/go-escpos"
+)
+
+// Printer geometry for the TM-T20III on 80mm paper.  Messages print in font
+// B, which is 9 by 17 dots before magnification.
+const (
+	printWidthDots   = 576
+	dotsPerMM        = 8
+	fontBWidthDots   = 9
+	fontBHeightDots  = 17
+	lineSpacingDots  = 34 // default 1/6 inch line spacing
+	maxMagnification = 8
+)
+
+// lineWidth returns the number of normal characters that fit on a line at
+// the given magnification
+func lineWidth(mag int) int {
+	return printWidthDots / (fontBWidthDots * mag)
+}
+
+// lineHeightDots returns the paper fed by one line of text at the given
+// magnification
+func lineHeightDots(mag int) int {
+	return max(fontBHeightDots*mag, lineSpacingDots)
+}
+
+// linesLengthMM returns the length of paper used by n lines of text
+func linesLengthMM(n, mag int) float64 {
+	return float64(n*lineHeightDots(mag)) / dotsPerMM
+}
+
+// preFence starts and ends a preformatted block in a message
+const preFence = "```"
+
+// overflowMarker replaces the last character of a preformatted line that is
+// too wide to print
+const overflowMarker = "»"
+
+// tabStopList holds the columns that tabs advance to.  After the last
+// explicit stop tabs continue every interval columns.
+type tabStopList struct {
+	stops    []int
+	interval int
+}
+
+// parseTabStops parses either a single interval such as "8" or a comma
+// separated list of increasing columns such as "10,16,24"
+func parseTabStops(s string) (tabStopList, error) {
+	var result tabStopList
+	parts := strings.Split(s, ",")
+	for _, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			return result, fmt.Errorf("invalid tab stop %q", part)
+		}
+		if len(result.stops) > 0 && n <= result.stops[len(result.stops)-1] {
+			return result, fmt.Errorf("tab stops must increase: %q", s)
+		}
+		result.stops = append(result.stops, n)
+	}
+	if len(result.stops) == 1 {
+		return tabStopList{interval: result.stops[0]}, nil
+	}
+	// carry on at the spacing of the last two stops
+	result.interval = result.stops[len(result.stops)-1] - result.stops[len(result.stops)-2]
+	return result, nil
+}
+
+// next returns the column a tab at column col advances to
+func (t tabStopList) next(col int) int {
+	for _, stop := range t.stops {
+		if stop > col {
+			return stop
+		}
+	}
+	last := 0
+	if len(t.stops) > 0 {
+		last = t.stops[len(t.stops)-1]
+	}
+	if t.interval <= 0 {
+		return col + 1
+	}
+	return last + ((col-last)/t.interval+1)*t.interval
+}
+
+// expandTabs replaces tabs with spaces up to the next tab stop, counting
+// columns in printed width
+func expandTabs(line string, stops tabStopList) string {
+	if !strings.ContainsRune(line, '\t') {
+		return line
+	}
+	var sb strings.Builder
+	col := 0
+	for _, r := range line {
+		if r == '\t' {
+			next := stops.next(col)
+			sb.WriteString(strings.Repeat(" ", next-col))
+			col = next
+			continue
+		}
+		sb.WriteRune(r)
+		col += runeWidth(r)
+	}
+	return sb.String()
+}
+
+// truncateLine cuts a preformatted line down to maxWidth, marking the cut
+// with overflowMarker
+func truncateLine(line string, maxWidth int) string {
+	runes := []rune(line)
+	if maxWidth <= 0 || runesWidth(runes) <= maxWidth {
+		return line
+	}
+	width := 0
+	for i, r := range runes {
+		if width+runeWidth(r) > maxWidth-1 {
+			return string(runes[:i]) + overflowMarker
+		}
+		width += runeWidth(r)
+	}
+	return line
+}
+
+// layoutMessage turns a message into the lines to print, wrapping text to
+// maxWidth and laying out any table and preformatted blocks
+func layoutMessage(message string, maxWidth int) []string {
+	var out []string
+	tabs := conf().tabs
+
+	// Split by line breaks and expand tabs before anything is measured,
+	// including table cells
+	lines := strings.Split(message, "\n")
+	for i := range lines {
+		lines[i] = expandTabs(lines[i], tabs)
+	}
+	for i := 0; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), preFence) {
+			// Preformatted lines print verbatim, only cut if too wide
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), preFence) {
+					break
+				}
+				line := strings.TrimRight(lines[i], " \r")
+				out = append(out, visualOrder(truncateLine(line, maxWidth), textLevel(line)))
+			}
+			continue
+		}
+
+		if isTableRow(lines[i]) {
+			end := i
+			for end < len(lines) && isTableRow(lines[end]) {
+				end++
+			}
+			out = append(out, layoutTable(lines[i:end], maxWidth)...)
+			i = end - 1
+			continue
+		}
+
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			out = append(out, "") // Print blank line for empty lines
+			continue
+		}
+
+		// Use the Unicode-aware wrapping function, then put any right to
+		// left text into visual order at the level of the whole line.  Right
+		// to left paragraphs are aligned right.
+		level := textLevel(line)
+		for _, wrapped := range wrapTextUnicode(line, maxWidth) {
+			wrapped = visualOrder(wrapped, level)
+			if level == 1 {
+				wrapped = padCell(wrapped, maxWidth, column{align: escpos.AlignRight})
+			}
+			out = append(out, wrapped)
+		}
+	}
+	return out
+}
+
+// longestWord returns the printed width of the widest word in a message.
+// Lines inside ``` fences are left out as they are cut rather than wrapped.
+func longestWord(message string) int {
+	longest := 0
+	fenced := false
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), preFence) {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			continue
+		}
+		for _, word := range strings.Fields(line) {
+			longest = max(longest, runesWidth([]rune(word)))
+		}
+	}
+	return longest
+}
+
+// fitMessage lays out a message at the largest magnification at which it
+// fits in maxLines lines and maxLengthMM millimetres of paper without
+// splitting any words.  A zero limit is not checked.  If nothing larger
+// fits the message is laid out at magnification 1.
+func fitMessage(message string, maxLines int, maxLengthMM float64) (int, []string) {
+	widest := longestWord(message)
+	for mag := maxMagnification; mag > 1; mag-- {
+		width := lineWidth(mag)
+		if widest > width {
+			continue
+		}
+		lines := layoutMessage(message, width)
+		if maxLines > 0 && len(lines) > maxLines {
+			continue
+		}
+		if maxLengthMM > 0 && linesLengthMM(len(lines), mag) > maxLengthMM {
+			continue
+		}
+		return mag, lines
+	}
+	return 1, layoutMessage(message, lineWidth(1))
+}
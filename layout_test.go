@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTabStops(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected tabStopList
+		wantErr  bool
+	}{
+		{"Interval", "8", tabStopList{interval: 8}, false},
+		{"List", "10,16,24", tabStopList{stops: []int{10, 16, 24}, interval: 8}, false},
+		{"Spaces in list", "4, 10", tabStopList{stops: []int{4, 10}, interval: 6}, false},
+		{"Not a number", "x", tabStopList{}, true},
+		{"Zero", "0", tabStopList{}, true},
+		{"Decreasing", "10,5", tabStopList{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseTabStops(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTabStops(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("parseTabStops(%q) = %+v, want %+v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExpandTabs(t *testing.T) {
+	every4 := tabStopList{interval: 4}
+	list := tabStopList{stops: []int{6, 10}, interval: 4}
+	tests := []struct {
+		name     string
+		line     string
+		stops    tabStopList
+		expected string
+	}{
+		{"No tabs", "abc", every4, "abc"},
+		{"Tab at start", "\tx", every4, "    x"},
+		{"Tab relative to column", "ab\tx", every4, "ab  x"},
+		{"Tab on a stop", "abcd\tx", every4, "abcd    x"},
+		{"Two tabs", "a\tb\tc", every4, "a   b   c"},
+		{"Full-width characters count double", "世\tx", every4, "世  x"},
+		{"Explicit stops", "a\tb\tc", list, "a     b   c"},
+		{"Past the last stop", "abcdefghijk\tx", list, "abcdefghijk   x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := expandTabs(tt.line, tt.stops)
+			if result != tt.expected {
+				t.Errorf("expandTabs(%q) = %q, want %q", tt.line, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTruncateLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		maxWidth int
+		expected string
+	}{
+		{"Fits", "abc", 5, "abc"},
+		{"Exactly fits", "abcde", 5, "abcde"},
+		{"Too wide", "abcdefg", 5, "abcd»"},
+		{"Full-width", "世界世界", 5, "世界»"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := truncateLine(tt.line, tt.maxWidth)
+			if result != tt.expected {
+				t.Errorf("truncateLine(%q, %d) = %q, want %q", tt.line, tt.maxWidth, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLayoutMessagePreformatted(t *testing.T) {
+	message := "Config\n```\n  key:\tvalue\n  a very long preformatted line\n```\nDone"
+	expected := []string{"Config", "  key:  value", "  a very lon»", "Done"}
+	result := layoutMessage(message, 13)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("layoutMessage(%q) = %q, want %q", message, result, expected)
+	}
+}
+
+func TestLineWidth(t *testing.T) {
+	if got := lineWidth(2); got != maxLineLength {
+		t.Errorf("lineWidth(2) = %d, want maxLineLength %d", got, maxLineLength)
+	}
+	if got := lineWidth(1); got != 64 {
+		t.Errorf("lineWidth(1) = %d, want 64", got)
+	}
+}
+
+func TestFitMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		message     string
+		maxLines    int
+		maxLengthMM float64
+		expectedMag int
+	}{
+		{"Short word prints largest", "Milk", 6, 0, 8},
+		{"Long word limits size", "Supercalifragilistic", 6, 0, 3},
+		{"Line limit", "Take the bins out on Tuesday morning", 2, 0, 3},
+		{"Length limit", "Milk", 0, 10, 4},
+		{"Nothing fits", strings.Repeat("word ", 20), 1, 0, 1},
+		{"Long preformatted line", "Milk\n```\n" + strings.Repeat("=", 80) + "\n```", 6, 0, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mag, lines := fitMessage(tt.message, tt.maxLines, tt.maxLengthMM)
+			if mag != tt.expectedMag {
+				t.Errorf("fitMessage(%q) magnification = %d (%q), want %d", tt.message, mag, lines, tt.expectedMag)
+			}
+			for _, line := range lines {
+				if runesWidth([]rune(line)) > lineWidth(mag) {
+					t.Errorf("fitMessage(%q) line %q wider than %d", tt.message, line, lineWidth(mag))
+				}
+			}
+		})
+	}
+}
+
+func TestLayoutMessageRightToLeft(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{"Paragraph level kept when wrapping", "שלום abc def", []string{"abc םולש", "     def"}},
+		{"Left to right unchanged", "abc שלום def", []string{"abc םולש", "def"}},
+		{"Preformatted", "```\nשלום abc\n```", []string{"abc םולש"}},
+		{"Table cell", "| שלום a | 4 |", []string{"a םולש 4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := layoutMessage(tt.message, 8); !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("layoutMessage(%q) = %q, want %q", tt.message, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+)
+
+// mediaType is the kind of paper loaded in the printer
+type mediaType string
+
+const (
+	mediaContinuous mediaType = "continuous" // plain roll, labels are cut to length
+	mediaGap        mediaType = "gap"        // die-cut labels with a gap between them
+	mediaBlackMark  mediaType = "blackmark"  // roll with a black mark at each label
+)
+
+// overflowPolicy says what to do with content too long for one label
+type overflowPolicy string
+
+const (
+	overflowPaginate overflowPolicy = "paginate" // carry on onto the next label
+	overflowShrink   overflowPolicy = "shrink"   // print smaller to fit one label
+	overflowRefuse   overflowPolicy = "refuse"   // fail the job
+)
+
+// mediaProfile describes the paper loaded and how content is fitted to it
+type mediaProfile struct {
+	Type     mediaType
+	LengthMM float64 // label length, unused for continuous media
+	Overflow overflowPolicy
+}
+
+// Heights of the fixed parts of a label in dots
+const (
+	headingDots       = 24 * 3 // font A at size 3
+	barcodeHeightDots = 100
+	labelFeedLines    = 2 // lines fed between message and barcode
+)
+
+// footerDots is the height of the feed, barcode, barcode text and timestamp
+// printed after a message at the given magnification
+func footerDots(mag int) int {
+	return labelFeedLines*lineSpacingDots + barcodeHeightDots + lineHeightDots(mag) + lineHeightDots(1)
+}
+
+// newMediaProfile checks and builds a media profile from its settings
+func newMediaProfile(kind string, lengthMM float64, overflow string) (mediaProfile, error) {
+	m := mediaProfile{
+		Type:     mediaType(kind),
+		LengthMM: lengthMM,
+		Overflow: overflowPolicy(overflow),
+	}
+	switch m.Type {
+	case mediaContinuous:
+		m.LengthMM = 0
+	case mediaGap, mediaBlackMark:
+		if lengthMM <= 0 {
+			return m, fmt.Errorf("%s media needs a label length", kind)
+		}
+		if m.lengthDots() <= headingDots+footerDots(1) {
+			return m, fmt.Errorf("label length %gmm is too short for a label", lengthMM)
+		}
+	default:
+		return m, fmt.Errorf("unknown media type %q", kind)
+	}
+	switch m.Overflow {
+	case overflowPaginate, overflowShrink, overflowRefuse:
+	default:
+		return m, fmt.Errorf("unknown overflow policy %q", overflow)
+	}
+	return m, nil
+}
+
+// fixedLength reports whether the media is cut into labels of set length
+func (m mediaProfile) fixedLength() bool {
+	return m.Type != mediaContinuous
+}
+
+// lengthDots returns the label length in dots
+func (m mediaProfile) lengthDots() int {
+	return int(m.LengthMM * dotsPerMM)
+}
+
+// messageSpaceMM returns the room for the message on a single label
+func (m mediaProfile) messageSpaceMM(mag int) float64 {
+	return float64(m.lengthDots()-headingDots-footerDots(mag)) / dotsPerMM
+}
+
+// paginate splits laid out message lines into the lines on each label.  The
+// first label also holds the heading and the last the footer.  Continuous
+// media always gives a single label.
+func (m mediaProfile) paginate(lines []string, mag int) ([][]string, error) {
+	if !m.fixedLength() {
+		return [][]string{lines}, nil
+	}
+
+	lineDots := lineHeightDots(mag)
+	foot := footerDots(mag)
+	var pages [][]string
+	page := []string{}
+	used := headingDots
+	for i, line := range lines {
+		remaining := len(lines) - i
+		if used+remaining*lineDots+foot <= m.lengthDots() {
+			// everything left fits with the footer
+			page = append(page, lines[i:]...)
+			used += remaining * lineDots
+			break
+		}
+		if used+lineDots > m.lengthDots() {
+			if len(page) == 0 {
+				return nil, fmt.Errorf("label too short to print a line at size %d", mag)
+			}
+			pages = append(pages, page)
+			page = []string{}
+			used = 0
+		}
+		page = append(page, line)
+		used += lineDots
+	}
+	if used+foot > m.lengthDots() {
+		// footer needs a label of its own
+		pages = append(pages, page)
+		page = []string{}
+	}
+	return append(pages, page), nil
+}
+
+// layoutLabels lays out a message for the loaded media, returning the
+// magnification and the lines for each label.  Fit mode and the shrink
+// policy pick the largest size that fits a label.
+func (m mediaProfile) layoutLabels(message string, fit bool) (int, [][]string, error) {
+	t := conf().Template
+	size := 2
+	lines := layoutMessage(message, t.LineLength)
+	switch {
+	case m.fixedLength() && (fit || m.Overflow == overflowShrink):
+		maxLength := m.messageSpaceMM(maxMagnification)
+		if fit && t.FitLength > 0 {
+			maxLength = min(maxLength, t.FitLength)
+		}
+		maxLines := 0
+		if fit {
+			maxLines = t.FitLines
+		}
+		size, lines = fitMessage(message, maxLines, maxLength)
+	case fit:
+		size, lines = fitMessage(message, t.FitLines, t.FitLength)
+	}
+
+	if m.fixedLength() && m.Overflow != overflowPaginate {
+		if linesLengthMM(len(lines), size) > m.messageSpaceMM(size) {
+			return 0, nil, fmt.Errorf("message too long for a %gmm label", m.LengthMM)
+		}
+	}
+
+	pages, err := m.paginate(lines, size)
+	return size, pages, err
+}
+
+// endLabel moves on to the start of the next label on fixed length media
+// (GS FF)
+func (m mediaProfile) endLabel() error {
+	if !m.fixedLength() {
+		return nil
+	}
+	return sendRaw(0x1D, 0x0C)
+}
+
+// paperUsedMM estimates the paper used by a label laid out in pages at the
+// given magnification
+func (m mediaProfile) paperUsedMM(pages [][]string, mag int) float64 {
+	if m.fixedLength() {
+		return float64(len(pages)) * m.LengthMM
+	}
+	lines := 0
+	for _, page := range pages {
+		lines += len(page)
+	}
+	return float64(headingDots+footerDots(mag))/dotsPerMM + linesLengthMM(lines, mag)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewMediaProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		lengthMM float64
+		overflow string
+		wantErr  bool
+	}{
+		{"Continuous", "continuous", 0, "paginate", false},
+		{"Gap", "gap", 50, "refuse", false},
+		{"Black mark", "blackmark", 80, "shrink", false},
+		{"Gap without length", "gap", 0, "paginate", true},
+		{"Label too short", "gap", 10, "paginate", true},
+		{"Unknown media", "fanfold", 50, "paginate", true},
+		{"Unknown overflow", "gap", 50, "squash", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newMediaProfile(tt.kind, tt.lengthMM, tt.overflow)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newMediaProfile(%q, %g, %q) error = %v, wantErr %v", tt.kind, tt.lengthMM, tt.overflow, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	lines := func(n int) []string {
+		return strings.Split(strings.Repeat("x\n", n-1)+"x", "\n")
+	}
+	// a 60mm label is 480 dots, the heading is 72 and the footer 236 at size
+	// 2 so with 34 dot lines a label holds 5 lines with both, 12 with just
+	// the heading and 14 with neither
+	gap := mediaProfile{Type: mediaGap, LengthMM: 60, Overflow: overflowPaginate}
+
+	tests := []struct {
+		name     string
+		media    mediaProfile
+		lines    int
+		expected []int // lines on each label
+	}{
+		{"Continuous is one label", mediaProfile{Type: mediaContinuous}, 40, []int{40}},
+		{"Fits one label", gap, 5, []int{5}},
+		{"Footer on its own label", gap, 9, []int{9, 0}},
+		{"Spills onto second label", gap, 15, []int{12, 3}},
+		{"Three labels", gap, 30, []int{12, 14, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := tt.media.paginate(lines(tt.lines), 2)
+			if err != nil {
+				t.Fatalf("paginate() error = %v", err)
+			}
+			var counts []int
+			for _, page := range pages {
+				counts = append(counts, len(page))
+			}
+			if len(counts) != len(tt.expected) {
+				t.Fatalf("paginate() label lines = %v, want %v", counts, tt.expected)
+			}
+			for i := range counts {
+				if counts[i] != tt.expected[i] {
+					t.Errorf("paginate() label lines = %v, want %v", counts, tt.expected)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestLayoutLabelsOverflow(t *testing.T) {
+	long := strings.Repeat("Check the stock in the back store room. ", 10)
+
+	refuse := mediaProfile{Type: mediaGap, LengthMM: 60, Overflow: overflowRefuse}
+	if _, _, err := refuse.layoutLabels(long, false); err == nil {
+		t.Errorf("layoutLabels() with refuse policy accepted a message too long for the label")
+	}
+	if _, pages, err := refuse.layoutLabels("Milk", false); err != nil || len(pages) != 1 {
+		t.Errorf("layoutLabels() with refuse policy = %d labels, %v, want 1 label", len(pages), err)
+	}
+
+	shrink := mediaProfile{Type: mediaGap, LengthMM: 60, Overflow: overflowShrink}
+	size, pages, err := shrink.layoutLabels("Take the bins out tonight", false)
+	if err != nil || len(pages) != 1 {
+		t.Fatalf("layoutLabels() with shrink policy = %d labels, %v, want 1 label", len(pages), err)
+	}
+	if linesLengthMM(len(pages[0]), size) > shrink.messageSpaceMM(size) {
+		t.Errorf("layoutLabels() with shrink policy overflowed the label at size %d", size)
+	}
+}
+
+func TestPrintLabelsStopsWhenFeedFails(t *testing.T) {
+	useConfigWith(t, func(c *config) {
+		c.Printer.Media = "gap"
+		c.Printer.LabelLength = 50
+	})
+	f := useFakePrinter(t)
+	f.fail = []byte{0x1D, 0x0C}
+	_, err := printLabels([]labelSpec{{"One", 1}, {"Two", 2}}, labelOptions{})
+	if err == nil || !strings.Contains(err.Error(), "next label") || isPermanent(err) {
+		t.Errorf("printLabels() error = %v, want the failed feed, retried as no label was confirmed", err)
+	}
+	if strings.Contains(f.written.String(), "Two") {
+		t.Errorf("printed the second label after the feed failed")
+	}
+}
+
+func TestPrintLabelsAfterFirstConfirmed(t *testing.T) {
+	useConfigWith(t, func(c *config) {
+		c.Printer.PrintTimeout = duration{50 * time.Millisecond}
+	})
+	f := useFakePrinter(t)
+	f.fail = []byte("Two")
+	_, err := printLabels([]labelSpec{{"One", 1}, {"Two", 2}}, labelOptions{})
+	if err == nil || !isPermanent(err) || !strings.Contains(err.Error(), "may have printed") {
+		t.Errorf("printLabels() error = %v, want it not retried once the first label was confirmed", err)
+	}
+}
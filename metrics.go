@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are kept by hand and written in the Prometheus text format so
+// golabel stays small enough for gokrazy.
+
+// latencyBuckets are the upper bounds in seconds of the print latency
+// histogram
+var latencyBuckets = []float64{0.5, 1, 2, 5, 10, 20, 30, 60}
+
+// jobLabels identify a series by printer and template
+type jobLabels struct {
+	printer  string
+	template string
+}
+
+func (l jobLabels) String() string {
+	return fmt.Sprintf(`printer=%q,template=%q`, l.printer, l.template)
+}
+
+// histogram counts observations into latencyBuckets
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metricsRegistry holds everything exported on /metrics
+type metricsRegistry struct {
+	mu        sync.Mutex
+	submitted map[jobLabels]uint64
+	printed   map[jobLabels]uint64
+	failed    map[jobLabels]uint64
+	latency   map[string]*histogram // by printer
+	lines     map[string]uint64     // by printer
+	paperMM   map[string]float64    // by printer
+	cuts      map[string]uint64     // by printer
+}
+
+// metrics is the registry for the whole process
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		submitted: map[jobLabels]uint64{},
+		printed:   map[jobLabels]uint64{},
+		failed:    map[jobLabels]uint64{},
+		latency:   map[string]*histogram{},
+		lines:     map[string]uint64{},
+		paperMM:   map[string]float64{},
+		cuts:      map[string]uint64{},
+	}
+}
+
+// jobSubmitted counts a job added to the queue
+func (m *metricsRegistry) jobSubmitted(j *job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.submitted[jobLabels{conf().Printer.Name, j.Template}]++
+}
+
+// jobFinished records the outcome of printing a job that took elapsed
+func (m *metricsRegistry) jobFinished(j *job, result labelResult, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	labels := jobLabels{conf().Printer.Name, j.Template}
+	if err != nil {
+		m.failed[labels]++
+		return
+	}
+	m.printed[labels]++
+	h := m.latency[labels.printer]
+	if h == nil {
+		h = &histogram{}
+		m.latency[labels.printer] = h
+	}
+	h.observe(elapsed.Seconds())
+	m.lines[labels.printer] += uint64(result.Lines)
+	m.paperMM[labels.printer] += result.PaperMM
+	m.cuts[labels.printer] += uint64(result.Cuts)
+}
+
+// writeHeader writes the HELP and TYPE lines for a metric
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeJobCounter writes a counter labelled by printer and template
+func writeJobCounter(w io.Writer, name, help string, values map[jobLabels]uint64) {
+	writeHeader(w, name, "counter", help)
+	keys := make([]jobLabels, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, k int) bool { return keys[i].String() < keys[k].String() })
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, k, values[k])
+	}
+}
+
+// writePrinterValues writes a metric labelled by printer only
+func writePrinterValues[V uint64 | float64](w io.Writer, name, kind, help string, values map[string]V) {
+	writeHeader(w, name, kind, help)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{printer=%q} %v\n", name, k, values[k])
+	}
+}
+
+// write writes every metric in the Prometheus text format, with the queue
+// and printer state as they are now
+func (m *metricsRegistry) write(w io.Writer, queueDepth int, status printerStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeJobCounter(w, "golabel_jobs_submitted_total", "Jobs added to the queue.", m.submitted)
+	writeJobCounter(w, "golabel_jobs_printed_total", "Jobs the printer confirmed printing.", m.printed)
+	writeJobCounter(w, "golabel_jobs_failed_total", "Jobs that failed to print.", m.failed)
+
+	name := "golabel_print_duration_seconds"
+	writeHeader(w, name, "histogram", "Time from starting a job to the printer confirming it was printed.")
+	printers := make([]string, 0, len(m.latency))
+	for k := range m.latency {
+		printers = append(printers, k)
+	}
+	sort.Strings(printers)
+	for _, printer := range printers {
+		h := m.latency[printer]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{printer=%q,le=\"%g\"} %d\n", name, printer, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{printer=%q,le=\"+Inf\"} %d\n", name, printer, h.count)
+		fmt.Fprintf(w, "%s_sum{printer=%q} %g\n", name, printer, h.sum)
+		fmt.Fprintf(w, "%s_count{printer=%q} %d\n", name, printer, h.count)
+	}
+
+	writePrinterValues(w, "golabel_lines_printed_total", "counter", "Message lines printed.", m.lines)
+	writePrinterValues(w, "golabel_paper_used_mm_total", "counter", "Millimetres of paper used, from the label layout.", m.paperMM)
+	writePrinterValues(w, "golabel_cuts_total", "counter", "Cuts made by the autocutter.", m.cuts)
+
+	writeHeader(w, "golabel_queue_depth", "gauge", "Jobs waiting or printing.")
+	fmt.Fprintf(w, "golabel_queue_depth %d\n", queueDepth)
+
+	printer := conf().Printer.Name
+	writeHeader(w, "golabel_printer_connected", "gauge", "Whether the printer is connected.")
+	fmt.Fprintf(w, "golabel_printer_connected{printer=%q} %d\n", printer, boolValue(status.Connected))
+
+	writeHeader(w, "golabel_printer_error", "gauge", "Printer error states from the real time status.")
+	states := []struct {
+		name string
+		set  bool
+	}{
+		{"offline", status.Offline},
+		{"cover_open", status.CoverOpen},
+		{"paper_end", status.PaperEnd},
+		{"paper_near_end", status.PaperNearEnd},
+		{"cutter_error", status.CutterError},
+		{"unrecoverable_error", status.Unrecoverable},
+		{"auto_recoverable_error", status.AutoRecoverable},
+	}
+	for _, s := range states {
+		fmt.Fprintf(w, "golabel_printer_error{printer=%q,state=%q} %d\n", printer, s.name, boolValue(s.set))
+	}
+}
+
+// boolValue turns a flag into a gauge value
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// handleMetrics serves the metrics for Prometheus to scrape
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var sb strings.Builder
+	metrics.write(&sb, queue.depth(), checkStatus())
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(sb.String()))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	m := newMetricsRegistry()
+	j := &job{ID: 1, Template: defaultTemplate}
+	m.jobSubmitted(j)
+	m.jobSubmitted(j)
+	m.jobFinished(j, labelResult{Lines: 3, PaperMM: 52.5, Cuts: 1}, 1500*time.Millisecond, nil)
+	m.jobFinished(j, labelResult{}, time.Second, errors.New("cutter error"))
+
+	var sb strings.Builder
+	m.write(&sb, 4, printerStatus{Connected: true, Known: true, CoverOpen: true})
+	out := sb.String()
+
+	expected := []string{
+		`golabel_jobs_submitted_total{printer="tm-t20iii",template="task"} 2`,
+		`golabel_jobs_printed_total{printer="tm-t20iii",template="task"} 1`,
+		`golabel_jobs_failed_total{printer="tm-t20iii",template="task"} 1`,
+		`golabel_print_duration_seconds_bucket{printer="tm-t20iii",le="1"} 0`,
+		`golabel_print_duration_seconds_bucket{printer="tm-t20iii",le="2"} 1`,
+		`golabel_print_duration_seconds_bucket{printer="tm-t20iii",le="+Inf"} 1`,
+		`golabel_print_duration_seconds_sum{printer="tm-t20iii"} 1.5`,
+		`golabel_lines_printed_total{printer="tm-t20iii"} 3`,
+		`golabel_paper_used_mm_total{printer="tm-t20iii"} 52.5`,
+		`golabel_cuts_total{printer="tm-t20iii"} 1`,
+		`golabel_queue_depth 4`,
+		`golabel_printer_connected{printer="tm-t20iii"} 1`,
+		`golabel_printer_error{printer="tm-t20iii",state="cover_open"} 1`,
+		`golabel_printer_error{printer="tm-t20iii",state="paper_end"} 0`,
+		`# TYPE golabel_print_duration_seconds histogram`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics output missing %q", line)
+		}
+	}
+}
+
+func TestPaperUsedMM(t *testing.T) {
+	gap := mediaProfile{Type: mediaGap, LengthMM: 50}
+	if got := gap.paperUsedMM([][]string{{"a"}, {"b"}}, 2); got != 100 {
+		t.Errorf("paperUsedMM() on gap media = %g, want 100", got)
+	}
+
+	roll := mediaProfile{Type: mediaContinuous}
+	// 72 heading + 2 lines of 34 + 236 footer = 376 dots
+	if got := roll.paperUsedMM([][]string{{"a", "b"}}, 2); got != 47 {
+		t.Errorf("paperUsedMM() on continuous media = %g, want 47", got)
+	}
+}
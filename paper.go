@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// usageDays is how many days of paper use are kept for forecasting
+const usageDays = 30
+
+// forecastDays is how far back the daily usage average looks
+const forecastDays = 14
+
+// maxRollLength is the longest roll in metres, far more than any roll the
+// printer takes
+const maxRollLength = 1000
+
+// checkRollLength checks that a roll length in metres is a real length
+func checkRollLength(metres float64) error {
+	if math.IsNaN(metres) || math.IsInf(metres, 0) || metres <= 0 || metres > maxRollLength {
+		return fmt.Errorf("roll length must be more than 0 and at most %dm", maxRollLength)
+	}
+	return nil
+}
+
+// paperLedger tracks the paper used by a printer and on its current roll.
+// It is saved as JSON in the state directory after every change.
+type paperLedger struct {
+	mu   sync.Mutex
+	path string // file the ledger is saved to, empty to keep it in memory
+
+	TotalMM      float64            `json:"totalMM"`      // all paper ever used
+	RollMM       float64            `json:"rollMM"`       // used since the roll was changed
+	RollLengthMM float64            `json:"rollLengthMM"` // length of the roll when new
+	RollChanged  time.Time          `json:"rollChanged"`
+	Days         map[string]float64 `json:"days"` // mm used per day, by date
+}
+
+// paperReport is the paper state shown on the web page
+type paperReport struct {
+	RemainingM  float64
+	DaysLeft    float64 // -1 when there is not enough history to tell
+	RollChanged time.Time
+	Low         bool
+}
+
+// paper is the ledger for the printer
+var paper = &paperLedger{Days: map[string]float64{}}
+
+// loadPaperLedger reads the ledger for the named printer from dir, starting
+// a new one with a full roll of rollLengthMM if there is none
+func loadPaperLedger(dir, printer string, rollLengthMM float64) (*paperLedger, error) {
+	l := &paperLedger{
+		RollLengthMM: rollLengthMM,
+		RollChanged:  time.Now(),
+		Days:         map[string]float64{},
+	}
+	if dir == "" {
+		return l, nil
+	}
+	l.path = filepath.Join(dir, "paper-"+printer+".json")
+	b, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	if err := json.Unmarshal(b, l); err != nil {
+		return l, fmt.Errorf("reading %s: %w", l.path, err)
+	}
+	if l.Days == nil {
+		l.Days = map[string]float64{}
+	}
+	return l, nil
+}
+
+// save writes the ledger out, replacing the old file in one step.  The
+// caller must hold l.mu.
+func (l *paperLedger) save() error {
+	if l.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// record adds paper used at time now
+func (l *paperLedger) record(mm float64, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.TotalMM += mm
+	l.RollMM += mm
+	l.Days[now.Format("2006-01-02")] += mm
+
+	// forget days too old to matter
+	cutoff := now.AddDate(0, 0, -usageDays).Format("2006-01-02")
+	for day := range l.Days {
+		if day < cutoff {
+			delete(l.Days, day)
+		}
+	}
+	return l.save()
+}
+
+// changeRoll records a new roll being loaded, of lengthMM or the same
+// length as the last roll if lengthMM is zero
+func (l *paperLedger) changeRoll(lengthMM float64, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lengthMM > 0 {
+		l.RollLengthMM = lengthMM
+	}
+	l.RollMM = 0
+	l.RollChanged = now
+	return l.save()
+}
+
+// dailyUsageMM averages the paper used per day over the last forecastDays,
+// or since the roll was changed if that is more recent.  Today counts as a
+// whole day.
+func (l *paperLedger) dailyUsageMM(now time.Time) float64 {
+	start := now.AddDate(0, 0, -(forecastDays - 1))
+	if l.RollChanged.After(start) {
+		start = l.RollChanged
+	}
+	startDay := start.Format("2006-01-02")
+	days := 1 + int(now.Sub(start).Hours()/24)
+
+	var total float64
+	for day, mm := range l.Days {
+		if day >= startDay {
+			total += mm
+		}
+	}
+	return total / float64(days)
+}
+
+// report works out how much paper is left and how long it will last.  The
+// paper is low when below warnMM or when the near end sensor says so.
+func (l *paperLedger) report(now time.Time, warnMM float64, nearEnd bool) paperReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	remaining := math.Max(l.RollLengthMM-l.RollMM, 0)
+	r := paperReport{
+		RemainingM:  remaining / 1000,
+		DaysLeft:    -1,
+		RollChanged: l.RollChanged,
+		Low:         nearEnd || remaining < warnMM,
+	}
+	if perDay := l.dailyUsageMM(now); perDay > 0 {
+		r.DaysLeft = remaining / perDay
+	}
+	return r
+}
+
+// handleRollChange records that a new roll has been loaded.  The length in
+// metres is optional.
+func handleRollChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	var lengthMM float64
+	if s := r.FormValue("length"); s != "" {
+		metres, err := strconv.ParseFloat(s, 64)
+		if err == nil {
+			err = checkRollLength(metres)
+		}
+		if err != nil {
+			http.Error(w, "invalid roll length", http.StatusBadRequest)
+			return
+		}
+		lengthMM = metres * 1000
+	}
+	e := newAuditEvent(r, auditPaperRoll)
+	e.Detail = "new roll loaded"
+	if lengthMM > 0 {
+		e.Detail = fmt.Sprintf("new %gm roll loaded", lengthMM/1000)
+	}
+	audit.record(e)
+	if err := paper.changeRoll(lengthMM, time.Now()); err != nil {
+		renderPage(w, r, "Roll change recorded but not saved: "+err.Error(), false)
+		return
+	}
+	renderPage(w, r, "New paper roll recorded", true)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaperLedgerForecast(t *testing.T) {
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	l := &paperLedger{RollLengthMM: 10000, RollChanged: start, Days: map[string]float64{}}
+
+	// 500mm a day for four days
+	for day := 0; day < 4; day++ {
+		if err := l.record(500, start.AddDate(0, 0, day)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := start.AddDate(0, 0, 3).Add(time.Hour)
+	r := l.report(now, 1000, false)
+	if r.RemainingM != 8 {
+		t.Errorf("report() remaining = %gm, want 8m", r.RemainingM)
+	}
+	if math.Abs(r.DaysLeft-16) > 0.01 {
+		t.Errorf("report() days left = %g, want 16", r.DaysLeft)
+	}
+	if r.Low {
+		t.Errorf("report() low with 8m left and a 1m threshold")
+	}
+	if !l.report(now, 9000, false).Low {
+		t.Errorf("report() not low with 8m left and a 9m threshold")
+	}
+	if !l.report(now, 1000, true).Low {
+		t.Errorf("report() not low when the near end sensor is set")
+	}
+
+	if err := l.changeRoll(0, now); err != nil {
+		t.Fatal(err)
+	}
+	// only today's use counts after the roll change
+	r = l.report(now, 1000, false)
+	if r.RemainingM != 10 || r.DaysLeft != 20 {
+		t.Errorf("report() after a roll change = %+v, want 10m left lasting 20 days", r)
+	}
+	if r := l.report(now.AddDate(0, 0, 1), 1000, false); r.DaysLeft != 40 {
+		t.Errorf("report() the day after a roll change = %+v, want 40 days left", r)
+	}
+	if l.TotalMM != 2000 {
+		t.Errorf("total paper = %gmm, want 2000mm", l.TotalMM)
+	}
+}
+
+func TestPaperLedgerPersists(t *testing.T) {
+	dir := t.TempDir()
+	l, err := loadPaperLedger(dir, "test", 80000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	l.record(120, now)
+	l.changeRoll(50000, now)
+	l.record(30, now)
+
+	again, err := loadPaperLedger(dir, "test", 80000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.TotalMM != 150 || again.RollMM != 30 || again.RollLengthMM != 50000 {
+		t.Errorf("reloaded ledger = total %g roll %g length %g, want 150, 30, 50000",
+			again.TotalMM, again.RollMM, again.RollLengthMM)
+	}
+}
+
+func TestPaperLedgerForgetsOldDays(t *testing.T) {
+	now := time.Date(2026, 3, 31, 9, 0, 0, 0, time.UTC)
+	l := &paperLedger{Days: map[string]float64{"2026-01-01": 100}}
+	l.record(10, now)
+	if _, ok := l.Days["2026-01-01"]; ok || len(l.Days) != 1 {
+		t.Errorf("record() kept old days: %v", l.Days)
+	}
+}
+
+func TestHandleRollChangeRejectsBadLengths(t *testing.T) {
+	for _, length := range []string{"0", "-5", "abc", "Inf", "NaN", "1e9"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/paper/roll", strings.NewReader(url.Values{"length": {length}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handleRollChange(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("roll change with length %q = %d, want 400", length, w.Code)
+		}
+	}
+}
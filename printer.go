@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mect/go-escpos"
+)
+
+// usbDevDir is where the usblp driver creates printer devices
+const usbDevDir = "/dev/usb"
+
+// replyTimeout is how long to wait for the printer to answer a request
+const replyTimeout = 500 * time.Millisecond
+
+// errNoReply is returned when the printer does not answer a request in time
+var errNoReply = errors.New("no reply from printer")
+
+// errDisconnected is returned when the printer is not plugged in or has gone
+// away while printing
+var errDisconnected = errors.New("printer disconnected")
+
+// dev is the raw connection underneath p, used for the ESC/POS commands the
+// driver has no method for and for reading replies
+var dev *printerConn
+
+// devicePath is the device dev was opened from
+var devicePath string
+
+// printMu is held while talking to the printer so that a status request
+// does not land in the middle of a label.  It also guards p, dev and
+// devicePath which change as the printer comes and goes.
+var printMu sync.Mutex
+
+// deviceMu also guards devicePath and lastOpenError, so the monitoring pages
+// can read them without waiting for a job to finish.  Both locks are held to
+// change them.
+var deviceMu sync.Mutex
+
+// printerConn wraps the printer device, reading everything the printer sends
+// back in the background so replies can be waited for with a timeout
+type printerConn struct {
+	rw      io.ReadWriteCloser
+	replies chan byte
+	dead    atomic.Bool // set once the device has failed
+}
+
+// newPrinterConn starts reading replies from rw
+func newPrinterConn(rw io.ReadWriteCloser) *printerConn {
+	c := &printerConn{rw: rw, replies: make(chan byte, 256)}
+	go c.readReplies()
+	return c
+}
+
+// readReplies copies bytes from the printer until the device fails or is
+// closed
+func (c *printerConn) readReplies() {
+	buf := make([]byte, 64)
+	for {
+		n, err := c.rw.Read(buf)
+		for _, b := range buf[:n] {
+			select {
+			case c.replies <- b:
+			default: // nobody is listening, drop it
+			}
+		}
+		switch {
+		case n == 0 && (err == nil || errors.Is(err, io.EOF)):
+			// usblp gives empty reads when the printer has nothing to say
+			time.Sleep(10 * time.Millisecond)
+		case err != nil && !errors.Is(err, io.EOF):
+			c.dead.Store(true)
+			close(c.replies)
+			return
+		}
+	}
+}
+
+// Read is not used, replies are read with readByte
+func (c *printerConn) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+// Write sends bytes to the printer
+func (c *printerConn) Write(b []byte) (int, error) {
+	n, err := c.rw.Write(b)
+	if err != nil {
+		c.dead.Store(true)
+	}
+	return n, err
+}
+
+// Close closes the device, which also stops readReplies
+func (c *printerConn) Close() error {
+	return c.rw.Close()
+}
+
+// discardReplies throws away anything the printer sent unasked
+func (c *printerConn) discardReplies() {
+	for {
+		select {
+		case _, ok := <-c.replies:
+			if !ok {
+				return // the device has failed
+			}
+		default:
+			return
+		}
+	}
+}
+
+// readByte waits up to timeout for the next byte from the printer
+func (c *printerConn) readByte(timeout time.Duration) (byte, error) {
+	select {
+	case b, ok := <-c.replies:
+		if !ok {
+			return 0, io.EOF
+		}
+		return b, nil
+	case <-time.After(timeout):
+		return 0, errNoReply
+	}
+}
+
+// request sends a command and returns the single byte reply
+func (c *printerConn) request(cmd ...byte) (byte, error) {
+	if c.dead.Load() {
+		return 0, errDisconnected
+	}
+	c.discardReplies()
+	if _, err := c.Write(cmd); err != nil {
+		return 0, err
+	}
+	return c.readByte(replyTimeout)
+}
+
+// waitFor reads from the printer until it has seen reply, giving up after
+// timeout
+func (c *printerConn) waitFor(reply []byte, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	matched := 0
+	for matched < len(reply) {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return errNoReply
+		}
+		b, err := c.readByte(remaining)
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == reply[matched]:
+			matched++
+		case b == reply[0]:
+			matched = 1
+		default:
+			matched = 0
+		}
+	}
+	return nil
+}
+
+// findPrinter returns the path of the first USB printer device
+func findPrinter() (string, error) {
+	entries, err := os.ReadDir(usbDevDir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "lp") {
+			return path.Join(usbDevDir, entry.Name()), nil
+		}
+	}
+	return "", escpos.ErrorNoDevicesFound
+}
+
+// openPrinter opens the printer at devPath, or the first USB printer found
+// if devPath is empty.  The caller must hold printMu.
+func openPrinter(devPath string) (err error) {
+	defer func() {
+		if err != nil {
+			deviceMu.Lock()
+			lastOpenError.Err = err.Error()
+			lastOpenError.When = time.Now()
+			deviceMu.Unlock()
+		}
+	}()
+
+	if devPath == "" {
+		devPath, err = findPrinter()
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(devPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("couldn't open %q device: %w", devPath, err)
+	}
+	conn := newPrinterConn(f)
+	p, err = escpos.NewPrinterByRW(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	dev = conn
+	deviceMu.Lock()
+	devicePath = devPath
+	deviceMu.Unlock()
+	return nil
+}
+
+// closePrinter drops the connection to the printer.  The caller must hold
+// printMu.
+func closePrinter() {
+	if dev != nil {
+		dev.Close()
+	}
+	p, dev = nil, nil
+	deviceMu.Lock()
+	devicePath = ""
+	deviceMu.Unlock()
+}
+
+// deviceState returns the device the printer was opened from and the last
+// failure to open it, without waiting for printMu
+func deviceState() (string, errorSummary) {
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+	return devicePath, errorSummary{Message: lastOpenError.Err, When: lastOpenError.When}
+}
+
+// printerConnected reports whether the printer is open and working.  The
+// caller must hold printMu.
+func printerConnected() bool {
+	return dev != nil && !dev.dead.Load()
+}
+
+// sendRaw writes command bytes straight to the printer
+func sendRaw(cmd ...byte) error {
+	if dev == nil {
+		return fmt.Errorf("printer not initialized")
+	}
+	_, err := dev.Write(cmd)
+	return err
+}
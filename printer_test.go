@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mect/go-escpos"
+)
+
+// fakePrinter stands in for the printer device, answering real time status
+// requests from a table of replies, confirming transmission requests at once
+// and recording everything written
+type fakePrinter struct {
+	mu      sync.Mutex
+	written bytes.Buffer
+	status  map[byte]byte // DLE EOT n reply for each n
+	silent  bool          // ignore transmission requests
+	fail    []byte        // a command whose write fails
+	replies chan []byte
+	closed  chan struct{}
+}
+
+func newFakePrinter() *fakePrinter {
+	return &fakePrinter{
+		status:  map[byte]byte{1: 0x12, 2: 0x12, 3: 0x12, 4: 0x12},
+		replies: make(chan []byte, 16),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (f *fakePrinter) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail != nil && bytes.Equal(b, f.fail) {
+		return 0, errors.New("write failed")
+	}
+	f.written.Write(b)
+	if len(b) == 3 && b[0] == 0x10 && b[1] == 0x04 {
+		if reply, ok := f.status[b[2]]; ok {
+			f.replies <- []byte{reply}
+		}
+	}
+	if len(b) == 11 && bytes.HasPrefix(b, processIDCommand("")) && !f.silent {
+		f.replies <- processIDReply(string(b[7:]))
+	}
+	return len(b), nil
+}
+
+func (f *fakePrinter) Read(b []byte) (int, error) {
+	select {
+	case reply := <-f.replies:
+		return copy(b, reply), nil
+	case <-f.closed:
+		return 0, os.ErrClosed
+	}
+}
+
+func (f *fakePrinter) Close() error {
+	close(f.closed)
+	return nil
+}
+
+// useFakePrinter connects the package to a fake printer for one test
+func useFakePrinter(t *testing.T) *fakePrinter {
+	t.Helper()
+	f := newFakePrinter()
+	dev = newPrinterConn(f)
+	p, _ = escpos.NewPrinterByRW(dev)
+	t.Cleanup(func() {
+		dev.Close()
+		p, dev = nil, nil
+	})
+	return f
+}
+
+func TestPrinterConnRequest(t *testing.T) {
+	useFakePrinter(t)
+	b, err := dev.request(0x10, 0x04, 1)
+	if err != nil || b != 0x12 {
+		t.Errorf("request() = %#x, %v, want 0x12", b, err)
+	}
+
+	start := time.Now()
+	if _, err := dev.request(0x10, 0x04, 9); err != errNoReply {
+		t.Errorf("request() with no reply error = %v, want %v", err, errNoReply)
+	}
+	if time.Since(start) < replyTimeout {
+		t.Errorf("request() with no reply returned before the timeout")
+	}
+}
+
+func TestPrinterConnFailed(t *testing.T) {
+	f := newFakePrinter()
+	c := newPrinterConn(f)
+	saved := dev
+	dev = c
+	t.Cleanup(func() { dev = saved })
+	f.Close() // reads now fail as when the printer is unplugged
+	for !c.dead.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	discarded := make(chan struct{})
+	go func() {
+		c.discardReplies()
+		close(discarded)
+	}()
+	select {
+	case <-discarded:
+	case <-time.After(time.Second):
+		t.Fatal("discardReplies() did not return after the device failed")
+	}
+	if _, err := c.request(0x10, 0x04, 1); err != errDisconnected {
+		t.Errorf("request() on a failed device error = %v, want %v", err, errDisconnected)
+	}
+	if _, err := confirmPrinted(time.Second); err != errDisconnected {
+		t.Errorf("confirmPrinted() on a failed device error = %v, want %v", err, errDisconnected)
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// jobState is where a job has got to
+type jobState string
+
+const (
+	jobQueued    jobState = "queued"
+	jobPrinting  jobState = "printing"
+	jobPrinted   jobState = "printed"
+	jobRetrying  jobState = "retrying" // failed, waiting to try again
+	jobFailed    jobState = "failed"
+	jobCancelled jobState = "cancelled"
+)
+
+// errNotWaiting is returned for a job that is not in the queue
+var errNotWaiting = errors.New("no such job waiting")
+
+// errJobStarted is returned when a job is already printing
+var errJobStarted = errors.New("job is already printing")
+
+// recentJobs is how many finished jobs are kept for the web page
+const recentJobs = 50
+
+// deadJobs is how many failed jobs are kept to be resubmitted
+const deadJobs = 100
+
+// defaultTemplate names the task label layout, the only one so far
+const defaultTemplate = "task"
+
+// job is a batch of labels waiting to be, or that have been, printed
+type job struct {
+	ID        int
+	Key       string // idempotency key the client gave, if any
+	Seq       int    // order the job was put in the queue
+	Template  string
+	From      requester // who asked for it
+	Labels    []labelSpec
+	Options   labelOptions
+	PaperMM   float64 // paper estimated for it, reserved against the quota
+	State     jobState
+	Submitted time.Time
+	Printed   time.Time // when the printer confirmed it was printed
+	Finished  time.Time // when it was printed or failed
+	Attempts  int       // times printing has been tried
+	NextTry   time.Time // when a retrying job is tried again
+	Err       string
+	Dismissed bool          // taken off the failed list
+	done      chan struct{} // closed when the job has finished
+}
+
+// jobQueue feeds jobs to the printer one at a time in order.  While the
+// printer is disconnected jobs are held until it comes back.  With a spool
+// every change to a job is written to disk so the queue survives a restart.
+type jobQueue struct {
+	mu      sync.Mutex
+	nextID  int
+	nextSeq int
+	spool   *jobSpool // nil to keep jobs in memory only
+	pending []*job
+	recent  []*job // finished jobs, oldest first
+	dead    []*job // failed jobs that can be resubmitted, oldest first
+	held    bool   // waiting for the printer to reconnect
+	paused  bool   // stopped by hand, say while changing the roll
+	wake    chan struct{}
+}
+
+// queue is the job queue for the printer
+var queue = newJobQueue()
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{nextID: 1, wake: make(chan struct{}, 1)}
+}
+
+// submit adds a job printing labels to the end of the queue, or ahead of
+// the jobs that are not urgent if it is urgent
+func (q *jobQueue) submit(from requester, labels []labelSpec, opts labelOptions) *job {
+	j, _ := q.submitKeyed("", from, labels, opts)
+	return j
+}
+
+// submitKeyed is submit with an idempotency key.  If from already sent a
+// job with the key it returns that job instead and reports false.
+func (q *jobQueue) submitKeyed(key string, from requester, labels []labelSpec, opts labelOptions) (*job, bool) {
+	q.mu.Lock()
+	if old := q.keyed(from, key); old != nil {
+		q.mu.Unlock()
+		return old, false
+	}
+	j := &job{
+		ID:        q.nextID,
+		Key:       key,
+		Template:  defaultTemplate,
+		From:      from,
+		Labels:    labels,
+		Options:   opts,
+		PaperMM:   jobPaperMM(labels, opts),
+		State:     jobQueued,
+		Submitted: time.Now(),
+		done:      make(chan struct{}),
+	}
+	q.nextID++
+	q.insert(j)
+	q.spoolJob(j)
+	q.mu.Unlock()
+
+	metrics.jobSubmitted(j)
+	q.wakeUp()
+	return j, true
+}
+
+// keyed returns the job from sent with key, if it is still queued or
+// remembered.  The caller must hold q.mu.
+func (q *jobQueue) keyed(from requester, key string) *job {
+	if key == "" {
+		return nil
+	}
+	for _, jobs := range [][]*job{q.pending, q.recent, q.dead} {
+		for _, j := range jobs {
+			if j.Key == key && from.owns(j) {
+				return j
+			}
+		}
+	}
+	return nil
+}
+
+// findKeyed returns the job from sent with key, if there is one
+func (q *jobQueue) findKeyed(from requester, key string) (*job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := q.keyed(from, key)
+	return j, j != nil
+}
+
+// wakeUp tells the queue to look for work, for when a job is added or the
+// printer reconnects
+func (q *jobQueue) wakeUp() {
+	select {
+	case q.wake <- struct{}{}:
+	default: // already due to wake
+	}
+}
+
+// insert puts a job in the queue: at the end, or after the job printing or
+// being retried and any other urgent jobs if it is urgent.  The caller must hold q.mu.
+func (q *jobQueue) insert(j *job) {
+	j.Seq = q.nextSeq
+	q.nextSeq++
+	i := len(q.pending)
+	if j.Options.Urgent {
+		i = 0
+		for i < len(q.pending) && (q.pending[i].State == jobPrinting || q.pending[i].State == jobRetrying || q.pending[i].Options.Urgent) {
+			i++
+		}
+	}
+	q.pending = slices.Insert(q.pending, i, j)
+}
+
+// waiting finds a queued job that has not started printing.  The caller
+// must hold q.mu.
+func (q *jobQueue) waiting(id int) (int, error) {
+	for i, j := range q.pending {
+		if j.ID != id {
+			continue
+		}
+		if j.State == jobPrinting {
+			return 0, fmt.Errorf("%w: job %d", errJobStarted, id)
+		}
+		return i, nil
+	}
+	return 0, errNotWaiting
+}
+
+// cancel takes a job that has not started printing out of the queue, if
+// allow lets it
+func (q *jobQueue) cancel(id int, allow func(*job) error) (job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i, err := q.waiting(id)
+	if err != nil {
+		return job{}, err
+	}
+	j := q.pending[i]
+	if err := allow(j); err != nil {
+		return job{}, err
+	}
+	q.pending = slices.Delete(q.pending, i, i+1)
+	limits.release(j.From, j.PaperMM)
+	j.State = jobCancelled
+	j.Finished = time.Now()
+	q.remember(j)
+	q.spoolJob(j)
+	close(j.done)
+	return *j, nil
+}
+
+// promote marks a job that has not started printing as urgent, moving it up
+// the queue
+func (q *jobQueue) promote(id int) (job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i, err := q.waiting(id)
+	if err != nil {
+		return job{}, err
+	}
+	j := q.pending[i]
+	q.pending = slices.Delete(q.pending, i, i+1)
+	j.Options.Urgent = true
+	q.insert(j)
+	q.spoolJob(j)
+	return *j, nil
+}
+
+// setPaused stops or restarts printing.  A job already printing finishes.
+func (q *jobQueue) setPaused(paused bool) {
+	q.mu.Lock()
+	q.paused = paused
+	q.mu.Unlock()
+	if !paused {
+		q.wakeUp()
+	}
+}
+
+// isPaused reports whether printing has been paused
+func (q *jobQueue) isPaused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// queued returns copies of the jobs waiting or printing, in order
+func (q *jobQueue) queued() []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]job, len(q.pending))
+	for i, j := range q.pending {
+		jobs[i] = *j
+	}
+	return jobs
+}
+
+// next returns the job at the head of the queue, marking it as printing.
+// If the head is waiting to be retried it returns how long until then, so
+// labels still print in the order they were queued.
+func (q *jobQueue) next(now time.Time) (*job, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.paused || len(q.pending) == 0 {
+		return nil, 0
+	}
+	j := q.pending[0]
+	if j.NextTry.After(now) {
+		return nil, j.NextTry.Sub(now)
+	}
+	j.State = jobPrinting
+	q.spoolJob(j) // before printing, so a restart knows it may have printed
+	return j, 0
+}
+
+// sleep waits to be woken up, or for d if it is not zero
+func (q *jobQueue) sleep(d time.Duration) {
+	if d == 0 {
+		<-q.wake
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-q.wake:
+	case <-timer.C:
+	}
+}
+
+// hold puts a job that failed with err back to queued and holds the queue,
+// counting the attempt.  It reports false, leaving the job to finish, once
+// the job has no attempts left.
+func (q *jobQueue) hold(j *job, err error, policy retryConfig) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !policy.retries(j.Attempts+1, err) {
+		return false
+	}
+	j.Attempts++
+	j.State = jobQueued
+	j.Err = err.Error()
+	q.held = true
+	q.spoolJob(j)
+	return true
+}
+
+// finish records the outcome of printing a job.  A job that failed is tried
+// again later, as the retry policy allows, and then put on the dead letter
+// list.
+func (q *jobQueue) finish(j *job, printed time.Time, err error, policy retryConfig) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.held = false
+	j.Attempts++
+	now := time.Now()
+	if policy.retries(j.Attempts, err) {
+		j.State = jobRetrying
+		j.Err = err.Error()
+		j.NextTry = now.Add(policy.backoff(j.Attempts))
+		q.spoolJob(j)
+		return
+	}
+	j.State = jobPrinted
+	j.Printed = printed
+	j.Finished = now
+	j.Err = ""
+	if err != nil {
+		j.State = jobFailed
+		j.Err = err.Error()
+		limits.release(j.From, j.PaperMM)
+		q.dead = append(q.dead, j)
+		q.trimDead()
+	}
+	q.pending = slices.DeleteFunc(q.pending, func(p *job) bool { return p == j })
+	q.remember(j)
+	q.spoolJob(j)
+	close(j.done)
+}
+
+// remember adds a finished job to the history.  The caller must hold q.mu.
+func (q *jobQueue) remember(j *job) {
+	q.recent = append(q.recent, j)
+	q.trimRecent()
+}
+
+// trimRecent forgets the oldest finished jobs past recentJobs, keeping the
+// spool files of those still on the failed list.  The caller must hold q.mu.
+func (q *jobQueue) trimRecent() {
+	if len(q.recent) > recentJobs {
+		for _, old := range q.recent[:len(q.recent)-recentJobs] {
+			if !slices.Contains(q.dead, old) {
+				q.unspool(old)
+			}
+		}
+		q.recent = q.recent[len(q.recent)-recentJobs:]
+	}
+}
+
+// trimDead forgets the oldest failed jobs past deadJobs, keeping the spool
+// files of those still in the history.  The caller must hold q.mu.
+func (q *jobQueue) trimDead() {
+	if len(q.dead) > deadJobs {
+		for _, old := range q.dead[:len(q.dead)-deadJobs] {
+			if !slices.Contains(q.recent, old) {
+				q.unspool(old)
+			}
+		}
+		q.dead = q.dead[len(q.dead)-deadJobs:]
+	}
+}
+
+// holds reports whether a job that failed with err waits for the printer to
+// reconnect, which it does when the printer went before any of its labels
+// were confirmed printed
+func holds(err error) bool {
+	return errors.Is(err, errDisconnected) && !isPermanent(err)
+}
+
+// run prints jobs with print until the program exits
+func (q *jobQueue) run(print func(*job) (time.Time, error)) {
+	for {
+		j, wait := q.next(time.Now())
+		if j == nil {
+			q.sleep(wait)
+			continue
+		}
+		printed, err := print(j)
+		policy := conf().Retry
+		if holds(err) && q.hold(j, err, policy) {
+			<-q.wake
+			continue
+		}
+		q.finish(j, printed, err, policy)
+	}
+}
+
+// isHeld reports whether jobs are being held for the printer
+func (q *jobQueue) isHeld() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.held
+}
+
+// wait waits up to timeout for j to finish and reports whether it did.  It
+// gives up early when jobs are being held for the printer, printing is
+// paused or j failed and is waiting to be retried.
+func (q *jobQueue) wait(j *job, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-j.done:
+			return true
+		case <-deadline:
+			return false
+		case <-tick.C:
+			if q.isHeld() || q.isPaused() || q.jobResult(j).State == jobRetrying {
+				return false
+			}
+		}
+	}
+}
+
+// depth returns the number of jobs waiting or printing
+func (q *jobQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// jobResult returns a copy of a job, safe to read while the queue runs
+func (q *jobQueue) jobResult(j *job) job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *j
+}
+
+// find returns a copy of the finished job with the given id, if it is still
+// in the history
+func (q *jobQueue) find(id int) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.recent {
+		if j.ID == id {
+			return *j, true
+		}
+	}
+	return job{}, false
+}
+
+// history returns copies of up to n finished jobs that keep accepts, newest
+// first
+func (q *jobQueue) history(n int, keep func(*job) bool) []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var jobs []job
+	for i := len(q.recent) - 1; i >= 0 && len(jobs) < n; i-- {
+		if keep(q.recent[i]) {
+			jobs = append(jobs, *q.recent[i])
+		}
+	}
+	return jobs
+}
+
+// deadLetters returns copies of the jobs that failed for good, newest first
+func (q *jobQueue) deadLetters() []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]job, 0, len(q.dead))
+	for i := len(q.dead) - 1; i >= 0; i-- {
+		jobs = append(jobs, *q.dead[i])
+	}
+	return jobs
+}
+
+// findDead returns a copy of the failed job with the given id
+func (q *jobQueue) findDead(id int) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.dead {
+		if j.ID == id {
+			return *j, true
+		}
+	}
+	return job{}, false
+}
+
+// dismiss takes a job off the failed list, if allow lets it
+func (q *jobQueue) dismiss(id int, allow func(*job) error) (job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := slices.IndexFunc(q.dead, func(j *job) bool { return j.ID == id })
+	if i < 0 {
+		return job{}, errNoFailedJob
+	}
+	j := q.dead[i]
+	if err := allow(j); err != nil {
+		return job{}, err
+	}
+	q.dead = slices.Delete(q.dead, i, i+1)
+	j.Dismissed = true
+	if slices.Contains(q.recent, j) {
+		q.spoolJob(j) // so a restart does not put it back on the list
+	} else {
+		q.unspool(j)
+	}
+	return *j, nil
+}
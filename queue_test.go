@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobQueuePrintsInOrder(t *testing.T) {
+	useConfigWith(t, func(c *config) { c.Retry.MaxAttempts = 1 })
+	q := newJobQueue()
+	var printedIDs []int
+	go q.run(func(j *job) (time.Time, error) {
+		printedIDs = append(printedIDs, j.ID)
+		if j.Labels[0].Message == "bad" {
+			return time.Time{}, errors.New("cutter error")
+		}
+		return time.Now(), nil
+	})
+
+	first := q.submit(requester{}, []labelSpec{{"one", 1}}, labelOptions{})
+	bad := q.submit(requester{}, []labelSpec{{"bad", 2}}, labelOptions{})
+	last := q.submit(requester{}, []labelSpec{{"three", 3}}, labelOptions{})
+	if !q.wait(last, time.Second) {
+		t.Fatalf("job %d did not finish", last.ID)
+	}
+
+	if got := q.jobResult(first); got.State != jobPrinted || got.Printed.IsZero() {
+		t.Errorf("first job = %+v, want printed", got)
+	}
+	if got := q.jobResult(bad); got.State != jobFailed || got.Err != "cutter error" {
+		t.Errorf("bad job = %+v, want failed with cutter error", got)
+	}
+	if len(printedIDs) != 3 || printedIDs[0] != 1 || printedIDs[1] != 2 || printedIDs[2] != 3 {
+		t.Errorf("jobs printed in order %v, want [1 2 3]", printedIDs)
+	}
+	if q.depth() != 0 {
+		t.Errorf("depth() = %d after all jobs finished", q.depth())
+	}
+}
+
+func TestJobQueueHoldsWhileDisconnected(t *testing.T) {
+	q := newJobQueue()
+	connected := make(chan bool, 1)
+	connected <- false
+	go q.run(func(j *job) (time.Time, error) {
+		ok := <-connected
+		connected <- ok
+		if !ok {
+			return time.Time{}, errDisconnected
+		}
+		return time.Now(), nil
+	})
+
+	j := q.submit(requester{}, []labelSpec{{"held", 1}}, labelOptions{})
+	if q.wait(j, time.Second) {
+		t.Fatalf("job finished while the printer was disconnected")
+	}
+	if !q.isHeld() || q.depth() != 1 || q.jobResult(j).State != jobQueued {
+		t.Fatalf("queue not holding the job: held %v depth %d state %s", q.isHeld(), q.depth(), q.jobResult(j).State)
+	}
+
+	<-connected
+	connected <- true
+	q.wakeUp()
+	if !q.wait(j, time.Second) {
+		t.Fatalf("held job not printed after reconnecting")
+	}
+	if got := q.jobResult(j); got.State != jobPrinted {
+		t.Errorf("held job = %+v, want printed", got)
+	}
+}
+
+func TestJobQueueHeldJobRunsOutOfAttempts(t *testing.T) {
+	useConfigWith(t, func(c *config) { c.Retry.MaxAttempts = 2 })
+	q := newJobQueue()
+	go q.run(func(j *job) (time.Time, error) { return time.Time{}, errDisconnected })
+
+	j := q.submit(requester{}, []labelSpec{{"held", 1}}, labelOptions{})
+	deadline := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case <-j.done:
+			done = true
+		case <-deadline:
+			t.Fatalf("held job still queued after using up its attempts")
+		case <-time.After(10 * time.Millisecond):
+			q.wakeUp() // the printer came back and went again
+		}
+	}
+	if got := q.jobResult(j); got.State != jobFailed || got.Attempts != 2 || len(q.deadLetters()) != 1 {
+		t.Errorf("held job = %+v, want failed after 2 attempts", got)
+	}
+}
+
+func TestJobQueueUrgentCancelAndPause(t *testing.T) {
+	q := newJobQueue()
+	var printedIDs []int
+	go q.run(func(j *job) (time.Time, error) {
+		printedIDs = append(printedIDs, j.ID)
+		return time.Now(), nil
+	})
+
+	q.setPaused(true)
+	a := q.submit(requester{}, []labelSpec{{"a", 1}}, labelOptions{})
+	b := q.submit(requester{}, []labelSpec{{"b", 2}}, labelOptions{})
+	c := q.submit(requester{}, []labelSpec{{"c", 3}}, labelOptions{Urgent: true})
+	d := q.submit(requester{}, []labelSpec{{"d", 4}}, labelOptions{})
+	if q.wait(a, 200*time.Millisecond) {
+		t.Fatalf("job printed while paused")
+	}
+	if _, err := q.promote(d.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := q.cancel(b.ID, func(*job) error { return nil })
+	if err != nil || got.State != jobCancelled {
+		t.Fatalf("cancel() = %+v, %v, want cancelled", got, err)
+	}
+	if _, err := q.cancel(b.ID, func(*job) error { return nil }); !errors.Is(err, errNotWaiting) {
+		t.Errorf("cancel() twice = %v, want errNotWaiting", err)
+	}
+	refused := errors.New("not yours")
+	if _, err := q.cancel(a.ID, func(*job) error { return refused }); err != refused || q.depth() != 3 {
+		t.Errorf("cancel() refused = %v with depth %d, want the job kept", err, q.depth())
+	}
+
+	q.setPaused(false)
+	if !q.wait(a, time.Second) {
+		t.Fatalf("job %d did not print after resuming", a.ID)
+	}
+	want := []int{c.ID, d.ID, a.ID}
+	if len(printedIDs) != len(want) || printedIDs[0] != want[0] || printedIDs[1] != want[1] || printedIDs[2] != want[2] {
+		t.Errorf("jobs printed in order %v, want %v", printedIDs, want)
+	}
+}
+
+func TestJobQueueCannotCancelPrinting(t *testing.T) {
+	q := newJobQueue()
+	release := make(chan struct{})
+	go q.run(func(j *job) (time.Time, error) {
+		<-release
+		return time.Now(), nil
+	})
+	j := q.submit(requester{}, []labelSpec{{"slow", 1}}, labelOptions{})
+	for q.jobResult(j).State != jobPrinting {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := q.cancel(j.ID, func(*job) error { return nil }); !errors.Is(err, errJobStarted) {
+		t.Errorf("cancel() of a printing job = %v, want errJobStarted", err)
+	}
+	if _, err := q.promote(j.ID); !errors.Is(err, errJobStarted) {
+		t.Errorf("promote() of a printing job = %v, want errJobStarted", err)
+	}
+	close(release)
+	q.wait(j, time.Second)
+}
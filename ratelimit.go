@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// pruneBuckets is how many rate limit buckets are kept before full ones are
+// thrown away
+const pruneBuckets = 1000
+
+// limitError is returned when a client has printed too much, saying when it
+// can try again
+type limitError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *limitError) Error() string {
+	wait := e.retryAfter.Round(time.Second)
+	if wait > time.Minute {
+		wait = e.retryAfter.Round(time.Minute)
+	}
+	return fmt.Sprintf("%s, please try again in %s", e.reason, wait)
+}
+
+// retryAfterSeconds is the value of the Retry-After header, at least one
+func (e *limitError) retryAfterSeconds() int {
+	return max(int(math.Ceil(e.retryAfter.Seconds())), 1)
+}
+
+// bucket is a token bucket counting labels
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// clientLimits keeps the label rate of each IP, API token and user, and the
+// paper each user, or IP without auth, has used today or has reserved for
+// jobs still in the queue.  Everything is kept in memory so it starts afresh
+// after a restart.
+type clientLimits struct {
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	day        string
+	paperMM    map[string]float64
+	reservedMM map[string]float64
+}
+
+// limits are the rate limits and quotas for all clients
+var limits = newClientLimits()
+
+func newClientLimits() *clientLimits {
+	return &clientLimits{buckets: map[string]*bucket{}, paperMM: map[string]float64{}, reservedMM: map[string]float64{}}
+}
+
+// rateKeys returns the bucket keys and labels per minute that apply to a
+// request
+func rateKeys(from requester, l limitsConfig) map[string]int {
+	keys := map[string]int{}
+	if l.IPPerMinute > 0 {
+		keys["ip:"+from.IP] = l.IPPerMinute
+	}
+	switch {
+	case from.User == "":
+	case from.Token && l.TokenPerMinute > 0:
+		keys["token:"+from.User] = l.TokenPerMinute
+	case !from.Token && l.UserPerMinute > 0:
+		keys["user:"+from.User] = l.UserPerMinute
+	}
+	return keys
+}
+
+// quotaKey is who the paper quota is counted against
+func quotaKey(from requester) string {
+	switch {
+	case from.User == "":
+		return "ip:" + from.IP
+	case from.Token:
+		return "token:" + from.User
+	}
+	return "user:" + from.User
+}
+
+// admitJob checks that from may print a job of labels now, estimating the
+// paper it will use from its layout
+func (c *clientLimits) admitJob(from requester, labels []labelSpec, opts labelOptions, now time.Time) error {
+	return c.admit(from, len(labels)*opts.copies(), jobPaperMM(labels, opts), now)
+}
+
+// jobPaperMM estimates the paper a job will use.  Labels that cannot be laid
+// out are not counted as the job fails before printing them.
+func jobPaperMM(labels []labelSpec, opts labelOptions) float64 {
+	m := conf().media
+	total := 0.0
+	for _, l := range labels {
+		if size, pages, err := m.layoutLabels(l.Message, opts.Fit); err == nil {
+			total += m.paperUsedMM(pages, size)
+		}
+	}
+	return total * float64(opts.copies())
+}
+
+// admit checks that from may print count labels using about paperMM of
+// paper now and takes them from its rate limits.  The paper is reserved
+// against the quota along with that of its jobs still in the queue, so
+// that together they cannot go past it.
+func (c *clientLimits) admit(from requester, count int, paperMM float64, now time.Time) error {
+	l := conf().Limits
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if quota := l.DailyPaper * 1000; quota > 0 {
+		c.newDay(now)
+		if paperMM > quota {
+			return fmt.Errorf("this job needs about %.1fm of paper, more than the daily quota of %gm", paperMM/1000, l.DailyPaper)
+		}
+		key := quotaKey(from)
+		if used := c.paperMM[key] + c.reservedMM[key]; used+paperMM > quota {
+			reason := fmt.Sprintf("The daily paper quota of %gm is used up", l.DailyPaper)
+			if used < quota {
+				reason = fmt.Sprintf("Only %.1fm of the daily paper quota of %gm is left", (quota-used)/1000, l.DailyPaper)
+			}
+			midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+			return &limitError{reason: reason, retryAfter: midnight.Sub(now)}
+		}
+	}
+
+	// check every limit before taking from any of them
+	keys := rateKeys(from, l)
+	for key, perMinute := range keys {
+		if count > perMinute {
+			return fmt.Errorf("%d labels is more than the limit of %d a minute", count, perMinute)
+		}
+		b := c.refill(key, perMinute, now)
+		if b.tokens < float64(count) {
+			wait := (float64(count) - b.tokens) / float64(perMinute) * 60
+			return &limitError{
+				reason:     "A lot of labels have been printed in a short time",
+				retryAfter: time.Duration(wait * float64(time.Second)),
+			}
+		}
+	}
+	for key := range keys {
+		c.buckets[key].tokens -= float64(count)
+	}
+	c.prune(now)
+	c.reservedMM[quotaKey(from)] += paperMM
+	return nil
+}
+
+// refill tops up a bucket for the time since it was last used.  The caller
+// must hold c.mu.
+func (c *clientLimits) refill(key string, perMinute int, now time.Time) *bucket {
+	b, ok := c.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(perMinute), updated: now}
+		c.buckets[key] = b
+	}
+	b.tokens = math.Min(b.tokens+now.Sub(b.updated).Minutes()*float64(perMinute), float64(perMinute))
+	b.updated = now
+	return b
+}
+
+// prune forgets buckets that have had a minute to refill, once there are
+// too many.  The caller must hold c.mu.
+func (c *clientLimits) prune(now time.Time) {
+	if len(c.buckets) <= pruneBuckets {
+		return
+	}
+	for key, b := range c.buckets {
+		if now.Sub(b.updated) > time.Minute {
+			delete(c.buckets, key)
+		}
+	}
+}
+
+// newDay clears the paper used when the day changes.  Paper reserved for
+// queued jobs is kept until they print.  The caller must hold c.mu.
+func (c *clientLimits) newDay(now time.Time) {
+	if day := now.Format("2006-01-02"); day != c.day {
+		c.day = day
+		clear(c.paperMM)
+	}
+}
+
+// usedPaper counts paper printed for a client against its daily quota in
+// place of the reservedMM it was admitted with
+func (c *clientLimits) usedPaper(from requester, reservedMM, mm float64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.newDay(now)
+	c.unreserve(from, reservedMM)
+	c.paperMM[quotaKey(from)] += mm
+}
+
+// reserve holds paper against a client's quota for a job put back in the
+// queue after a restart
+func (c *clientLimits) reserve(from requester, mm float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reservedMM[quotaKey(from)] += mm
+}
+
+// release gives back the paper reserved for a job that will not print
+func (c *clientLimits) release(from requester, mm float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unreserve(from, mm)
+}
+
+// unreserve takes mm off a client's reserved paper.  The caller must hold
+// c.mu.
+func (c *clientLimits) unreserve(from requester, mm float64) {
+	key := quotaKey(from)
+	if left := c.reservedMM[key] - mm; left > 0.001 {
+		c.reservedMM[key] = left
+	} else {
+		delete(c.reservedMM, key)
+	}
+}
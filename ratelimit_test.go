@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLimits sets the limits for one test and starts with fresh counts
+func useLimits(t *testing.T, l limitsConfig) {
+	t.Helper()
+	useConfigWith(t, func(c *config) { c.Limits = l })
+	saved := limits
+	limits = newClientLimits()
+	t.Cleanup(func() { limits = saved })
+}
+
+func TestAdmitRateLimits(t *testing.T) {
+	useLimits(t, limitsConfig{IPPerMinute: 10, TokenPerMinute: 4, UserPerMinute: 6})
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	alice := requester{User: "alice", IP: "10.0.0.2"}
+	script := requester{User: "inventory", Token: true, IP: "10.0.0.3"}
+
+	steps := []struct {
+		name    string
+		from    requester
+		count   int
+		after   time.Duration
+		limited bool
+	}{
+		{"User within limit", alice, 6, 0, false},
+		{"User over limit", alice, 1, 0, true},
+		{"User refilled", alice, 1, 10 * time.Second, false},
+		{"Token within limit", script, 4, 0, false},
+		{"Token over limit", script, 2, 0, true},
+		{"Other IP unaffected", requester{IP: "10.0.0.9"}, 10, 0, false},
+		{"Same IP shares the IP limit", requester{IP: "10.0.0.9"}, 1, 0, true},
+	}
+
+	for _, s := range steps {
+		now = now.Add(s.after)
+		err := limits.admit(s.from, s.count, 0, now)
+		var limited *limitError
+		if errors.As(err, &limited) != s.limited {
+			t.Fatalf("%s: admit() error = %v, limited %v", s.name, err, s.limited)
+		}
+		if s.limited && limited.retryAfterSeconds() < 1 {
+			t.Errorf("%s: retry after %v", s.name, limited.retryAfter)
+		}
+	}
+
+	// a job bigger than the limit can never be admitted
+	if err := limits.admit(alice, 7, 0, now.Add(time.Hour)); err == nil || errors.As(err, new(*limitError)) {
+		t.Errorf("admit() of a job over the limit error = %v, want a plain error", err)
+	}
+}
+
+func TestAdmitDailyPaper(t *testing.T) {
+	useLimits(t, limitsConfig{DailyPaper: 1})
+	now := time.Date(2025, 3, 1, 18, 0, 0, 0, time.UTC)
+	bob := requester{User: "bob", IP: "10.0.0.2"}
+
+	if err := limits.admit(bob, 1, 500, now); err != nil {
+		t.Fatal(err)
+	}
+	limits.usedPaper(bob, 500, 600, now)
+	err := limits.admit(bob, 1, 500, now)
+	var limited *limitError
+	if !errors.As(err, &limited) || limited.retryAfter != 6*time.Hour {
+		t.Fatalf("admit() of a job going past the quota = %v, want to wait 6h until midnight", err)
+	}
+	if err := limits.admit(bob, 1, 300, now); err != nil {
+		t.Errorf("admit() of a job within what is left = %v", err)
+	}
+	err = limits.admit(requester{User: "carol", IP: "10.0.0.2"}, 10, 1500, now)
+	if err == nil || errors.As(err, new(*limitError)) {
+		t.Errorf("admit() of a job bigger than the quota = %v, want a plain error", err)
+	}
+	if err := limits.admit(requester{User: "carol", IP: "10.0.0.2"}, 1, 500, now); err != nil {
+		t.Errorf("quota of another user used: %v", err)
+	}
+	if err := limits.admit(bob, 1, 500, now.Add(7*time.Hour)); err != nil {
+		t.Errorf("quota not reset the next day: %v", err)
+	}
+}
+
+func TestAdmitReservesPaper(t *testing.T) {
+	useLimits(t, limitsConfig{DailyPaper: 1})
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	dave := requester{User: "dave", IP: "10.0.0.2"}
+
+	// two queued jobs cannot overrun the quota between them
+	if err := limits.admit(dave, 1, 600, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := limits.admit(dave, 1, 600, now); !errors.As(err, new(*limitError)) {
+		t.Fatalf("admit() while the first job is queued = %v, want a limit error", err)
+	}
+
+	// a cancelled or failed job gives its paper back
+	limits.release(dave, 600)
+	if err := limits.admit(dave, 1, 600, now); err != nil {
+		t.Fatalf("admit() after the first job was released = %v", err)
+	}
+
+	// a printed job counts what it used rather than the estimate
+	limits.usedPaper(dave, 600, 300, now)
+	if err := limits.admit(dave, 1, 700, now); err != nil {
+		t.Errorf("admit() of what is left after printing = %v", err)
+	}
+	if err := limits.admit(dave, 1, 1, now); !errors.As(err, new(*limitError)) {
+		t.Errorf("admit() past the quota = %v, want a limit error", err)
+	}
+}
+
+func TestQueuedJobsShareDailyPaper(t *testing.T) {
+	estimate := jobPaperMM([]labelSpec{{Message: "Milk"}}, labelOptions{})
+	useRoles(t, testRoles)
+	useLimits(t, limitsConfig{DailyPaper: estimate * 1.5 / 1000})
+	useAudit(t)
+	q := useTestQueue(t, func(j *job) (time.Time, error) { return time.Now(), nil })
+	q.setPaused(true)
+
+	print := func() int {
+		w := httptest.NewRecorder()
+		handleAPIPrint(w, withUser(httptest.NewRequest("POST", "/api/print", strings.NewReader(`{"message":"Milk"}`)), "alice"))
+		return w.Code
+	}
+	if code := print(); code != 202 {
+		t.Fatalf("first job = %d, want 202 queued", code)
+	}
+	if code := print(); code != 429 {
+		t.Errorf("second job while the first is queued = %d, want 429", code)
+	}
+	if _, err := cancelJob(requester{User: "alice"}, q.queued()[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := print(); code != 202 {
+		t.Errorf("job after the first was cancelled = %d, want 202", code)
+	}
+}
+
+func TestAPIPrintRateLimited(t *testing.T) {
+	useLimits(t, limitsConfig{IPPerMinute: 2})
+	useTestQueue(t, func(j *job) (time.Time, error) { return time.Now(), nil })
+
+	codes := []int{}
+	for range 3 {
+		w := httptest.NewRecorder()
+		handleAPIPrint(w, httptest.NewRequest("POST", "/api/print", strings.NewReader(`{"message":"Milk"}`)))
+		codes = append(codes, w.Code)
+		if w.Code == 429 {
+			if w.Header().Get("Retry-After") != "30" {
+				t.Errorf("Retry-After = %q, want 30", w.Header().Get("Retry-After"))
+			}
+			var body map[string]string
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if !strings.Contains(body["error"], "try again") {
+				t.Errorf("429 error = %q", body["error"])
+			}
+		}
+	}
+	if codes[0] != 200 || codes[1] != 200 || codes[2] != 429 {
+		t.Errorf("status codes = %v, want 200 200 429", codes)
+	}
+}
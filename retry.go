@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// permanentError is a failure that printing again will not fix, such as a
+// label too long for the paper, or one after a label was confirmed printed
+// that printing again could print twice, so the job is not retried
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// isPermanent reports whether err should not be retried
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// mayHavePrinted marks a failure after the printer confirmed a label of the
+// job printed.  Others may have printed too, so the job goes on the failed
+// list for someone to check rather than printing again by itself.
+func mayHavePrinted(err error) error {
+	return permanentError{fmt.Errorf("%w; some labels may have printed", err)}
+}
+
+// retries reports whether a job that failed with err on attempt number
+// attempts is tried again
+func (rc retryConfig) retries(attempts int, err error) bool {
+	return err != nil && !isPermanent(err) && attempts < rc.MaxAttempts
+}
+
+// backoff returns how long to wait before trying a job again after
+// attempts failures: the backoff doubled for each failure after the first,
+// up to max_backoff
+func (rc retryConfig) backoff(attempts int) time.Duration {
+	wait := rc.Backoff.Duration
+	for i := 1; i < attempts && wait < rc.MaxBackoff.Duration; i++ {
+		wait *= 2
+	}
+	return min(wait, rc.MaxBackoff.Duration)
+}
+
+// errNoFailedJob is returned for a job that is not on the failed list
+var errNoFailedJob = errors.New("no such failed job")
+
+// failedJob is a job on the failed list, with what the viewer may do to it
+type failedJob struct {
+	job
+	CanResubmit bool
+}
+
+// failedJobs returns the failed jobs from may see, newest first.  Like
+// queued jobs, someone may act on their own or anyone's with manage_jobs.
+func failedJobs(c *config, from requester) []failedJob {
+	r := c.roleOf(from)
+	var jobs []failedJob
+	for _, j := range queue.deadLetters() {
+		if canCancel(r, from, &j) {
+			jobs = append(jobs, failedJob{job: j, CanResubmit: r.Can(permPrint)})
+		}
+	}
+	return jobs
+}
+
+// allowFailed checks that from may act on a failed job
+func allowFailed(from requester, verb string) func(*job) error {
+	r := conf().roleOf(from)
+	return func(j *job) error {
+		if !canCancel(r, from, j) {
+			return forbiddenError(fmt.Sprintf("your role cannot %s job %d", verb, j.ID))
+		}
+		return nil
+	}
+}
+
+// resubmit queues a failed job again as a new job printed by from, taking
+// it off the failed list
+func resubmit(from requester, id int) (*job, error) {
+	c := conf()
+	old, ok := queue.findDead(id)
+	if !ok {
+		return nil, errNoFailedJob
+	}
+	if err := allowFailed(from, "resubmit")(&old); err != nil {
+		return nil, err
+	}
+	if err := c.checkPrint(from, "", old.Template); err != nil {
+		return nil, err
+	}
+	if err := limits.admitJob(from, old.Labels, old.Options, time.Now()); err != nil {
+		return nil, err
+	}
+	if _, err := queue.dismiss(id, allowFailed(from, "resubmit")); err != nil {
+		return nil, err
+	}
+	opts := old.Options
+	opts.Urgent = false
+	return queue.submit(from, old.Labels, opts), nil
+}
+
+// dismissFailed takes a job off the failed list without printing it
+func dismissFailed(from requester, id int) (job, error) {
+	return queue.dismiss(id, allowFailed(from, "dismiss"))
+}
+
+// handleResubmit resubmits a failed job from the web page
+func handleResubmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	j, err := resubmit(requesterFrom(r), id)
+	if err != nil {
+		renderPage(w, r, "Error: "+err.Error(), false)
+		return
+	}
+	auditJob(r, auditResubmit, j, fmt.Sprintf("resubmit of job %d", id))
+	renderPage(w, r, fmt.Sprintf("Job %d queued again as job %d", id, j.ID), true)
+}
+
+// handleDismiss takes a job off the failed list from the web page
+func handleDismiss(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	j, err := dismissFailed(requesterFrom(r), id)
+	if err != nil {
+		renderPage(w, r, "Error: "+err.Error(), false)
+		return
+	}
+	auditJob(r, auditDismiss, &j, "")
+	renderPage(w, r, fmt.Sprintf("Failed job %d dismissed", id), true)
+}
+
+// handleAPIFailed lists the failed jobs the caller may resubmit or dismiss
+func handleAPIFailed(w http.ResponseWriter, r *http.Request) {
+	jobs := []jobSummary{}
+	for _, j := range failedJobs(conf(), requesterFrom(r)) {
+		jobs = append(jobs, summarizeJob(j.job))
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// handleAPIResubmit resubmits a failed job, answering like /api/print
+func handleAPIResubmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "POST only")
+		return
+	}
+	var req apiJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	j, err := resubmit(requesterFrom(r), req.ID)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	auditJob(r, auditResubmit, j, fmt.Sprintf("resubmit of job %d", req.ID))
+	writeJobResult(w, j)
+}
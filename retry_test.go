@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	rc := retryConfig{Backoff: duration{5 * time.Second}, MaxBackoff: duration{time.Minute}}
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{4, 40 * time.Second},
+		{5, time.Minute},
+		{40, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := rc.backoff(tt.attempts); got != tt.expected {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.expected)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	rc := retryConfig{MaxAttempts: 3}
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		expected bool
+	}{
+		{"Printed", 1, nil, false},
+		{"Not ready", 1, errors.New("printer not ready"), true},
+		{"Write error before a label was confirmed", 1, errors.New("write failed"), true},
+		{"Last attempt", 3, errors.New("printer not ready"), false},
+		{"Permanent", 1, permanentError{errors.New("label too long")}, false},
+		{"After sending", 1, mayHavePrinted(errors.New("no reply")), false},
+	}
+	for _, tt := range tests {
+		if got := rc.retries(tt.attempts, tt.err); got != tt.expected {
+			t.Errorf("%s: retries(%d, %v) = %v, want %v", tt.name, tt.attempts, tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestJobQueueRetries(t *testing.T) {
+	useConfigWith(t, func(c *config) {
+		c.Retry = retryConfig{MaxAttempts: 3, Backoff: duration{20 * time.Millisecond}, MaxBackoff: duration{50 * time.Millisecond}}
+	})
+	var mu sync.Mutex
+	tries := map[string]int{}
+	q := newJobQueue()
+	go q.run(func(j *job) (time.Time, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		message := j.Labels[0].Message
+		tries[message]++
+		switch {
+		case message == "flaky" && tries[message] < 3:
+			return time.Time{}, errors.New("printer not ready")
+		case message == "broken":
+			return time.Time{}, errors.New("cutter error")
+		case message == "too long":
+			return time.Time{}, permanentError{errors.New("label too long")}
+		}
+		return time.Now(), nil
+	})
+
+	flaky := q.submit(requester{}, []labelSpec{{"flaky", 1}}, labelOptions{})
+	after := q.submit(requester{}, []labelSpec{{"after", 2}}, labelOptions{})
+	broken := q.submit(requester{}, []labelSpec{{"broken", 3}}, labelOptions{})
+	long := q.submit(requester{}, []labelSpec{{"too long", 4}}, labelOptions{})
+	for _, j := range []*job{flaky, after, broken, long} {
+		select {
+		case <-j.done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("job %d did not finish", j.ID)
+		}
+	}
+
+	if got := q.jobResult(flaky); got.State != jobPrinted || got.Attempts != 3 || got.Err != "" {
+		t.Errorf("flaky job = %+v, want printed on the third attempt", got)
+	}
+	if got := q.jobResult(after); got.State != jobPrinted || got.Finished.Before(q.jobResult(flaky).Finished) {
+		t.Errorf("job after the flaky one = %+v, want printed after it", got)
+	}
+	if got := q.jobResult(broken); got.State != jobFailed || got.Attempts != 3 {
+		t.Errorf("broken job = %+v, want failed after 3 attempts", got)
+	}
+	if got := q.jobResult(long); got.State != jobFailed || got.Attempts != 1 {
+		t.Errorf("job with a permanent error = %+v, want failed without retrying", got)
+	}
+	dead := q.deadLetters()
+	if len(dead) != 2 || dead[0].ID != long.ID || dead[1].ID != broken.ID {
+		t.Errorf("dead letters = %+v, want the two failed jobs newest first", dead)
+	}
+}
+
+func TestResubmitAndDismiss(t *testing.T) {
+	useRoles(t, testRoles)
+	useAudit(t)
+	useConfigWith(t, func(c *config) { c.Retry.MaxAttempts = 1 })
+	jammed := true
+	var mu sync.Mutex
+	q := useTestQueue(t, func(j *job) (time.Time, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if jammed {
+			return time.Time{}, errors.New("cutter error")
+		}
+		return time.Now(), nil
+	})
+	alices := q.submit(requester{User: "alice"}, []labelSpec{{"Milk", 7}}, labelOptions{})
+	bobs := q.submit(requester{User: "bob"}, []labelSpec{{"Eggs", 8}}, labelOptions{})
+	q.wait(bobs, time.Second)
+
+	w := httptest.NewRecorder()
+	handleAPIFailed(w, withUser(httptest.NewRequest("GET", "/api/failed", nil), "alice"))
+	var failed []jobSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &failed); err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].ID != alices.ID || failed[0].Error != "cutter error" || failed[0].Attempts != 1 {
+		t.Errorf("GET /api/failed as alice = %+v, want only her failed job", failed)
+	}
+	tmpl = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+	w = httptest.NewRecorder()
+	renderPage(w, withUser(httptest.NewRequest("GET", "/", nil), "boss"), "", false)
+	if body := w.Body.String(); strings.Count(body, `action="/resubmit"`) != 2 {
+		t.Errorf("page for an admin does not offer to resubmit both failed jobs")
+	}
+
+	mu.Lock()
+	jammed = false
+	mu.Unlock()
+	dismiss := handleAPIJob(auditDismiss, dismissFailed)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		user    string
+		id      int
+		code    int
+	}{
+		{"Resubmit someone else's job", handleAPIResubmit, "alice", bobs.ID, 403},
+		{"Dismiss someone else's job", dismiss, "alice", bobs.ID, 403},
+		{"Resubmit own job", handleAPIResubmit, "alice", alices.ID, 200},
+		{"Resubmit it twice", handleAPIResubmit, "alice", alices.ID, 404},
+		{"Dismiss as admin", dismiss, "boss", bobs.ID, 200},
+		{"Dismiss it twice", dismiss, "boss", bobs.ID, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, jobRequest(tt.user, tt.id))
+			if w.Code != tt.code {
+				t.Errorf("%s as %s = %d %s, want %d", tt.name, tt.user, w.Code, w.Body.String(), tt.code)
+			}
+		})
+	}
+	if dead := q.deadLetters(); len(dead) != 0 {
+		t.Errorf("dead letters = %+v, want none left", dead)
+	}
+
+	events, _ := audit.read(conf().Audit.File, 1, auditFilter{Action: auditResubmit}, 10)
+	if len(events) != 1 || events[0].User != "alice" || !strings.Contains(events[0].Detail, fmt.Sprint(alices.ID)) {
+		t.Errorf("audited resubmits = %+v, want alice's", events)
+	}
+}
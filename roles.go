@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// permission is something a role allows
+type permission string
+
+const (
+	permPrint     permission = "print"       // print new labels
+	permReprint   permission = "reprint"     // print your own jobs again from the history
+	permManage    permission = "manage_jobs" // reprint or cancel anyone's jobs
+	permConfigure permission = "configure"   // record paper rolls and reload the config
+	permAudit     permission = "view_audit"  // read the audit log
+	permSchedule  permission = "schedule"    // add and change scheduled labels
+)
+
+// allPermissions are the permissions a role can have
+var allPermissions = []permission{permPrint, permReprint, permManage, permConfigure, permAudit, permSchedule}
+
+// anyName in a role's printers or templates allows all of them
+const anyName = "*"
+
+// templateNames are the label templates that can be printed
+var templateNames = []string{defaultTemplate}
+
+// forbiddenError is returned for something a role does not allow
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+
+// isForbidden reports whether err is a forbiddenError
+func isForbidden(err error) bool {
+	var forbidden forbiddenError
+	return errors.As(err, &forbidden)
+}
+
+// roleConfig is a [roles.<name>] section of the config
+type roleConfig struct {
+	Permissions []string `toml:"permissions"`
+	Printers    []string `toml:"printers"`  // printer names, or "*" for all
+	Templates   []string `toml:"templates"` // template names, or "*" for all
+}
+
+// builtinRoles are the roles there without a config file.  A role of the
+// same name in the file replaces one of these.
+func builtinRoles() map[string]roleConfig {
+	return map[string]roleConfig{
+		"admin": {
+			Permissions: []string{string(permPrint), string(permReprint), string(permManage), string(permConfigure), string(permAudit), string(permSchedule)},
+			Printers:    []string{anyName},
+			Templates:   []string{anyName},
+		},
+		"user": {
+			Permissions: []string{string(permPrint), string(permReprint)},
+			Printers:    []string{anyName},
+			Templates:   []string{anyName},
+		},
+		"viewer": {},
+	}
+}
+
+// role is what a user or API token may do
+type role struct {
+	Name             string
+	perms            map[permission]bool
+	allowedPrinters  []string
+	allowedTemplates []string
+}
+
+// newRole checks a role from the config
+func newRole(name string, rc roleConfig) (role, error) {
+	r := role{Name: name, perms: map[permission]bool{}, allowedPrinters: rc.Printers, allowedTemplates: rc.Templates}
+	for _, p := range rc.Permissions {
+		if !slices.Contains(allPermissions, permission(p)) {
+			return role{}, fmt.Errorf("roles.%s: unknown permission %q", name, p)
+		}
+		r.perms[permission(p)] = true
+	}
+	return r, nil
+}
+
+// Can reports whether the role has a permission
+func (r role) Can(p permission) bool {
+	return r.perms[p]
+}
+
+// allows reports whether name is in a role's list of printers or templates
+func allows(names []string, name string) bool {
+	return slices.Contains(names, anyName) || slices.Contains(names, name)
+}
+
+// choices returns the names the role allows out of those there are
+func choices(allowed, names []string) []string {
+	var out []string
+	for _, name := range names {
+		if allows(allowed, name) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// printers returns the printers the role may print to
+func (r role) printers(c *config) []string {
+	if !r.Can(permPrint) {
+		return nil
+	}
+	return choices(r.allowedPrinters, []string{c.Printer.Name})
+}
+
+// templates returns the templates the role may print
+func (r role) templates(c *config) []string {
+	if !r.Can(permPrint) {
+		return nil
+	}
+	return choices(r.allowedTemplates, templateNames)
+}
+
+// roleOf returns the role of whoever sent a request: their entry in
+// security.token_roles or security.user_roles, or the default role
+func (c *config) roleOf(from requester) role {
+	roles := c.Security.UserRoles
+	if from.Token {
+		roles = c.Security.TokenRoles
+	}
+	name, ok := roles[from.User]
+	if from.User == "" || !ok {
+		name = c.Security.DefaultRole
+	}
+	return c.roles[name]
+}
+
+// checkPrint checks that the printer and template asked for exist, empty
+// meaning the default, and that from may print with them
+func (c *config) checkPrint(from requester, printer, template string) error {
+	if printer == "" {
+		printer = c.Printer.Name
+	}
+	if template == "" {
+		template = defaultTemplate
+	}
+	if printer != c.Printer.Name {
+		return fmt.Errorf("unknown printer %q", printer)
+	}
+	if !slices.Contains(templateNames, template) {
+		return fmt.Errorf("unknown template %q", template)
+	}
+	r := c.roleOf(from)
+	switch {
+	case !r.Can(permPrint):
+		return forbiddenError("your role cannot print")
+	case !allows(r.allowedPrinters, printer):
+		return forbiddenError("your role cannot print to " + printer)
+	case !allows(r.allowedTemplates, template):
+		return forbiddenError("your role cannot print the " + template + " template")
+	}
+	return nil
+}
+
+// requirePermission serves a form only to someone whose role has p
+func requirePermission(p permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !conf().roleOf(requesterFrom(r)).Can(p) {
+			http.Error(w, "Your role does not allow this", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
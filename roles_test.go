@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRoles gives alice and bob the user role, boss the admin role, the
+// stock token a role of its own and everyone else, including a token named
+// alice, the viewer role
+const testRoles = `
+[printer]
+name = "shed"
+
+[security]
+default_role = "viewer"
+
+[security.api_tokens]
+stock = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+alice = "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+
+[security.user_roles]
+alice = "user"
+bob = "user"
+boss = "admin"
+
+[security.token_roles]
+stock = "packer"
+
+[roles.packer]
+permissions = ["print"]
+printers = ["shed"]
+templates = ["asset"]
+`
+
+// useRoles loads a config with roles for one test
+func useRoles(t *testing.T, text string) {
+	t.Helper()
+	useConfig(t)
+	c, err := loadConfig(writeConfig(t, text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentConfig.Store(c)
+}
+
+// withUser returns r as sent by user
+func withUser(r *http.Request, user string) *http.Request {
+	from := requester{User: user, IP: "192.0.2.1"}
+	return r.WithContext(context.WithValue(r.Context(), requesterKey{}, from))
+}
+
+func TestCheckPrint(t *testing.T) {
+	useRoles(t, testRoles)
+
+	tests := []struct {
+		name      string
+		from      requester
+		printer   string
+		template  string
+		forbidden bool
+		ok        bool
+	}{
+		{"User with defaults", requester{User: "alice"}, "", "", false, true},
+		{"User naming both", requester{User: "alice"}, "shed", "task", false, true},
+		{"Unknown printer", requester{User: "alice"}, "office", "", false, false},
+		{"Unknown template", requester{User: "alice"}, "", "asset", false, false},
+		{"Viewer", requester{User: "carol"}, "", "", true, false},
+		{"No user", requester{}, "", "", true, false},
+		{"Template not allowed", requester{User: "stock", Token: true}, "", "", true, false},
+		{"Token named like a user", requester{User: "alice", Token: true}, "", "", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := conf().checkPrint(tt.from, tt.printer, tt.template)
+			if (err == nil) != tt.ok || isForbidden(err) != tt.forbidden {
+				t.Errorf("checkPrint(%+v, %q, %q) = %v, want ok %v forbidden %v",
+					tt.from, tt.printer, tt.template, err, tt.ok, tt.forbidden)
+			}
+		})
+	}
+}
+
+func TestRoleConfig(t *testing.T) {
+	useRoles(t, testRoles+`
+[roles.user]
+permissions = ["print"]
+printers = ["*"]
+templates = ["task"]
+`)
+	c := conf()
+	tests := []struct {
+		from      requester
+		expected  string
+		reprint   bool
+		configure bool
+	}{
+		{requester{User: "alice"}, "user", false, false}, // the file replaces the built in user role
+		{requester{User: "boss"}, "admin", true, true},
+		{requester{User: "carol"}, "viewer", false, false},
+		{requester{User: "stock", Token: true}, "packer", false, false},
+		{requester{User: "stock"}, "viewer", false, false},
+		{requester{User: "alice", Token: true}, "viewer", false, false},
+	}
+	for _, tt := range tests {
+		r := c.roleOf(tt.from)
+		if r.Name != tt.expected || r.Can(permReprint) != tt.reprint || r.Can(permConfigure) != tt.configure {
+			t.Errorf("roleOf(%+v) = %+v, want %s", tt.from, r, tt.expected)
+		}
+	}
+	if got := c.roleOf(requester{User: "stock", Token: true}).templates(c); len(got) != 0 {
+		t.Errorf("packer templates = %v, want none", got)
+	}
+	if got := c.roleOf(requester{User: "alice"}).printers(c); len(got) != 1 || got[0] != "shed" {
+		t.Errorf("user printers = %v, want shed", got)
+	}
+}
+
+func TestRoleConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"Unknown permission", "[roles.clerk]\npermissions = [\"print\", \"delete\"]", "roles.clerk"},
+		{"Unknown default role", "[security]\ndefault_role = \"clerk\"", "default_role"},
+		{"Unknown user role", "[security.user_roles]\nalice = \"clerk\"", "user_roles.alice"},
+		{"Unknown token", "[security.token_roles]\nci = \"user\"", "token_roles.ci"},
+		{"Unknown token role", "[security.api_tokens]\nci = \"" + strings.Repeat("0", 64) + "\"\n[security.token_roles]\nci = \"clerk\"", "token_roles.ci"},
+		{"User roles with a shared password", "[security]\nauth = \"password\"\npassword_hash = \"$2y$10$abcdefghijklmnopqrstuu5Zl5bGWb8kY8Cq1OQ2TqKyB4R4Xoa3u\"\n[security.user_roles]\nalice = \"user\"", "user_roles"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, tt.text))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("loadConfig() error = %v, want it to mention %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReprint(t *testing.T) {
+	useRoles(t, testRoles)
+	useLimits(t, limitsConfig{})
+	q := useTestQueue(t, func(j *job) (time.Time, error) { return time.Now(), nil })
+
+	opts := labelOptions{Copies: 2, Cut: cutPartial}
+	alices := q.submit(requester{User: "alice"}, []labelSpec{{"Milk", 7}}, opts)
+	bobs := q.submit(requester{User: "bob"}, []labelSpec{{"Eggs", 8}}, opts)
+	q.wait(alices, time.Second)
+	q.wait(bobs, time.Second)
+	if got := len(reprintable(conf(), requester{User: "alice"})); got != 1 {
+		t.Errorf("alice can reprint %d jobs, want her own one", got)
+	}
+	if got := len(reprintable(conf(), requester{User: "alice", Token: true})); got != 0 {
+		t.Errorf("the alice token can reprint %d jobs, want none", got)
+	}
+
+	tests := []struct {
+		name string
+		user string
+		id   int
+		ok   bool
+	}{
+		{"Own job", "alice", alices.ID, true},
+		{"Someone else's job", "alice", bobs.ID, false},
+		{"Admin", "boss", bobs.ID, true},
+		{"Viewer", "carol", alices.ID, false},
+		{"Missing job", "boss", 99, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := reprint(requester{User: tt.user}, tt.id)
+			if (err == nil) != tt.ok {
+				t.Fatalf("reprint(%q, %d) error = %v, want ok %v", tt.user, tt.id, err, tt.ok)
+			}
+			if err != nil {
+				return
+			}
+			got := q.jobResult(j)
+			if got.From.User != tt.user || got.Options != opts || len(got.Labels) != 1 {
+				t.Errorf("reprinted job = %+v", got)
+			}
+		})
+	}
+}
+
+func TestRolesInHandlers(t *testing.T) {
+	useRoles(t, testRoles)
+	useTestQueue(t, func(j *job) (time.Time, error) { return time.Now(), nil })
+	tmpl = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+	// a viewer cannot print through the API
+	w := httptest.NewRecorder()
+	handleAPIPrint(w, withUser(httptest.NewRequest("POST", "/api/print", strings.NewReader(`{"message":"Milk"}`)), "carol"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("viewer POST /api/print = %d, want 403", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handleAPIPrint(w, withUser(httptest.NewRequest("POST", "/api/print", strings.NewReader(`{"message":"Milk","printer":"office"}`)), "alice"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/print to an unknown printer = %d, want 400", w.Code)
+	}
+
+	// only configure can record a roll change
+	roll := requirePermission(permConfigure, handleRollChange)
+	w = httptest.NewRecorder()
+	roll(w, withUser(httptest.NewRequest("POST", "/paper/roll", nil), "alice"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("user roll change = %d, want 403", w.Code)
+	}
+
+	// the page only shows what the role allows
+	tests := []struct {
+		user  string
+		print bool
+		roll  bool
+	}{
+		{"carol", false, false},
+		{"alice", true, false},
+		{"boss", true, true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		renderPage(w, withUser(httptest.NewRequest("GET", "/", nil), tt.user), "", false)
+		body := w.Body.String()
+		if strings.Contains(body, `action="/print"`) != tt.print || strings.Contains(body, `action="/paper/roll"`) != tt.roll {
+			t.Errorf("page for %s: print form %v, roll form %v, want %v %v", tt.user,
+				strings.Contains(body, `action="/print"`), strings.Contains(body, `action="/paper/roll"`), tt.print, tt.roll)
+		}
+	}
+}
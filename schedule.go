@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// schedulePollInterval is how often schedules are checked for being due
+const schedulePollInterval = 20 * time.Second
+
+// scheduleTimeFormat is the format of a one-off time in the schedule form,
+// as sent by a datetime-local input
+const scheduleTimeFormat = "2006-01-02T15:04"
+
+// schedule prints a label at a set time, once or again and again
+type schedule struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Cron      string    `json:"cron,omitempty"` // empty for a one-off
+	At        time.Time `json:"at"`             // when a one-off prints
+	Message   string    `json:"message"`        // a template with date fields
+	Barcode   int       `json:"barcode"`
+	Copies    int       `json:"copies"`
+	Owner     requester `json:"owner"` // who set it up, whom the labels are printed as
+	Enabled   bool      `json:"enabled"`
+	Next      time.Time `json:"next"` // zero when it will not run again
+	LastRun   time.Time `json:"lastRun"`
+	LastJob   int       `json:"lastJob,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// messageField is a field in a scheduled message, such as {{.Weekday}} or
+// {{.Now.Format "2 Jan"}}
+var messageField = regexp.MustCompile(`\{\{\s*(.*?)\s*\}\}`)
+
+// renderMessage fills the date fields into a scheduled message.  Only the
+// fields are filled in rather than running the message as a template, so a
+// message cannot loop or grow without end.
+func renderMessage(message string, now time.Time) (string, error) {
+	var sb strings.Builder
+	last := 0
+	for _, m := range messageField.FindAllStringSubmatchIndex(message, -1) {
+		sb.WriteString(message[last:m[0]])
+		value, err := messageValue(message[m[2]:m[3]], now)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(value)
+		last = m[1]
+	}
+	if strings.Contains(message[last:], "{{") {
+		return "", errors.New("message has {{ without a matching }}")
+	}
+	sb.WriteString(message[last:])
+	return sb.String(), nil
+}
+
+// messageValue returns the value of one field in a scheduled message
+func messageValue(field string, now time.Time) (string, error) {
+	switch field {
+	case ".Date":
+		return now.Format("2006-01-02"), nil
+	case ".Time":
+		return now.Format("15:04"), nil
+	case ".Weekday":
+		return now.Weekday().String(), nil
+	}
+	if quoted, ok := strings.CutPrefix(field, ".Now.Format "); ok {
+		if layout, err := strconv.Unquote(strings.TrimSpace(quoted)); err == nil {
+			return now.Format(layout), nil
+		}
+	}
+	return "", fmt.Errorf("message has unknown field {{%s}}; use .Date, .Time, .Weekday or .Now.Format \"layout\"", field)
+}
+
+// setNext works out when a schedule next prints after now
+func (s *schedule) setNext(now time.Time) {
+	s.Next = time.Time{}
+	switch {
+	case !s.Enabled:
+	case s.Cron != "":
+		if spec, err := parseCron(s.Cron); err == nil {
+			s.Next = spec.next(now)
+		}
+	case s.At.After(now) || s.LastRun.IsZero():
+		s.Next = s.At
+	}
+}
+
+// scheduleStore holds the schedules, saved as JSON in the state directory
+// after every change
+type scheduleStore struct {
+	mu        sync.Mutex
+	path      string      // empty to keep them in memory
+	NextID    int         `json:"nextID"`
+	Schedules []*schedule `json:"schedules"`
+}
+
+// schedules are the scheduled labels
+var schedules = &scheduleStore{NextID: 1}
+
+// loadSchedules reads the schedules kept in dir
+func loadSchedules(dir string) (*scheduleStore, error) {
+	s := &scheduleStore{NextID: 1}
+	if dir == "" {
+		return s, nil
+	}
+	s.path = filepath.Join(dir, "schedules.json")
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return s, fmt.Errorf("reading %s: %w", s.path, err)
+	}
+	return s, nil
+}
+
+// save writes the schedules out, replacing the old file in one step.  The
+// caller must hold s.mu.
+func (s *scheduleStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// list returns copies of the schedules, soonest first
+func (s *scheduleStore) list() []schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]schedule, len(s.Schedules))
+	for i, sc := range s.Schedules {
+		out[i] = *sc
+	}
+	slices.SortStableFunc(out, func(a, b schedule) int {
+		switch {
+		case a.Next.IsZero() && b.Next.IsZero():
+			return 0
+		case a.Next.IsZero():
+			return 1
+		case b.Next.IsZero():
+			return -1
+		}
+		return a.Next.Compare(b.Next)
+	})
+	return out
+}
+
+// add checks a new schedule and keeps it
+func (s *scheduleStore) add(sc schedule, now time.Time) (schedule, error) {
+	if err := checkSchedule(&sc, now); err != nil {
+		return schedule{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc.ID = s.NextID
+	s.NextID++
+	sc.Enabled = true
+	sc.setNext(now)
+	s.Schedules = append(s.Schedules, &sc)
+	return sc, s.save()
+}
+
+// checkSchedule checks the time and label of a schedule
+func checkSchedule(sc *schedule, now time.Time) error {
+	sc.Name = strings.TrimSpace(sc.Name)
+	if sc.Name == "" {
+		return fmt.Errorf("a schedule needs a name")
+	}
+	switch {
+	case sc.Cron != "" && !sc.At.IsZero():
+		return fmt.Errorf("give a cron expression or a time, not both")
+	case sc.Cron != "":
+		if _, err := parseCron(sc.Cron); err != nil {
+			return err
+		}
+	case sc.At.IsZero():
+		return fmt.Errorf("give a cron expression or a time")
+	case !sc.At.After(now):
+		return fmt.Errorf("the time %s has passed", sc.At.Format(scheduleTimeFormat))
+	}
+	message, err := renderMessage(sc.Message, now)
+	if err != nil {
+		return fmt.Errorf("message: %w", err)
+	}
+	if _, err := cleanMessage(message); err != nil {
+		return err
+	}
+	if sc.Barcode == 0 {
+		sc.Barcode = conf().Defaults.Barcode
+	}
+	if err := checkBarcode(sc.Barcode); err != nil {
+		return err
+	}
+	if sc.Copies == 0 {
+		sc.Copies = conf().Defaults.Copies
+	}
+	return checkJobSize(1, sc.Copies)
+}
+
+// errNoSchedule is returned for a schedule that does not exist
+var errNoSchedule = errors.New("no such schedule")
+
+// remove deletes a schedule
+func (s *scheduleStore) remove(id int) (schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.Schedules, func(sc *schedule) bool { return sc.ID == id })
+	if i < 0 {
+		return schedule{}, errNoSchedule
+	}
+	sc := *s.Schedules[i]
+	s.Schedules = slices.Delete(s.Schedules, i, i+1)
+	return sc, s.save()
+}
+
+// setEnabled turns a schedule on or off
+func (s *scheduleStore) setEnabled(id int, enabled bool, now time.Time) (schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.Schedules, func(sc *schedule) bool { return sc.ID == id })
+	if i < 0 {
+		return schedule{}, errNoSchedule
+	}
+	sc := s.Schedules[i]
+	sc.Enabled = enabled
+	sc.setNext(now)
+	return *sc, s.save()
+}
+
+// runDue queues the labels of schedules that are due at now.  A schedule
+// missed while golabel was down prints once when it starts again.
+func (s *scheduleStore) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ran := false
+	for _, sc := range s.Schedules {
+		if !sc.Enabled || sc.Next.IsZero() || sc.Next.After(now) {
+			continue
+		}
+		ran = true
+		sc.LastRun = now
+		sc.LastError = ""
+		if j, err := sc.submit(now); err != nil {
+			sc.LastError = err.Error()
+			fmt.Printf("Error printing schedule %q: %v\n", sc.Name, err)
+		} else {
+			sc.LastJob = j.ID
+		}
+		if sc.Cron == "" {
+			sc.Enabled = false
+		}
+		sc.setNext(now)
+	}
+	if ran {
+		if err := s.save(); err != nil {
+			fmt.Println("Error saving schedules:", err)
+		}
+	}
+}
+
+// submit renders a schedule's label and queues it as printed by its owner,
+// checked against their role and limits as a label from /api/print is
+func (sc *schedule) submit(now time.Time) (*job, error) {
+	c := conf()
+	from := sc.Owner
+	if err := c.checkPrint(from, "", defaultTemplate); err != nil {
+		return nil, err
+	}
+	message, err := renderMessage(sc.Message, now)
+	if err != nil {
+		return nil, err
+	}
+	if message, err = cleanMessage(message); err != nil {
+		return nil, err
+	}
+	d := c.Defaults
+	opts, err := parseLabelOptions(1, d.Fit, d.Alert, sc.Copies, d.Cut, d.CutAt)
+	if err != nil {
+		return nil, err
+	}
+	labels := []labelSpec{{Message: message, Barcode: sc.Barcode}}
+	if err := limits.admitJob(from, labels, opts, now); err != nil {
+		return nil, err
+	}
+	j := queue.submit(from, labels, opts)
+	e := auditEvent{Time: now, Action: auditPrint, User: from.User, Token: from.Token, IP: from.IP, Detail: "scheduled: " + sc.Name}
+	recordJob(e, j)
+	return j, nil
+}
+
+// runSchedules prints scheduled labels as they come due
+func runSchedules(s *scheduleStore) {
+	ticker := time.NewTicker(schedulePollInterval)
+	defer ticker.Stop()
+	for {
+		s.runDue(time.Now())
+		<-ticker.C
+	}
+}
+
+// ScheduleData is passed to the schedules page
+type ScheduleData struct {
+	Status    string
+	Success   bool
+	Schedules []schedule
+	CSRF      string
+}
+
+// renderSchedules shows the schedules page
+func renderSchedules(w http.ResponseWriter, r *http.Request, status string, success bool) {
+	data := ScheduleData{
+		Status:    status,
+		Success:   success,
+		Schedules: schedules.list(),
+		CSRF:      csrfToken(w, r),
+	}
+	if err := tmpl.ExecuteTemplate(w, "schedules.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleSchedules lists the schedules and adds new ones
+func handleSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderSchedules(w, r, "", false)
+		return
+	}
+	sc := schedule{
+		Name:    r.FormValue("name"),
+		Cron:    strings.TrimSpace(r.FormValue("cron")),
+		Message: r.FormValue("message"),
+		Owner:   requesterFrom(r),
+	}
+	sc.Barcode, _ = strconv.Atoi(r.FormValue("barcode"))
+	sc.Copies, _ = strconv.Atoi(r.FormValue("copies"))
+	if at := r.FormValue("at"); at != "" {
+		var err error
+		if sc.At, err = time.ParseInLocation(scheduleTimeFormat, at, time.Local); err != nil {
+			renderSchedules(w, r, "Error: the time must be like 2025-03-01T07:30", false)
+			return
+		}
+	}
+	sc, err := schedules.add(sc, time.Now())
+	if err != nil {
+		renderSchedules(w, r, "Error: "+err.Error(), false)
+		return
+	}
+	e := newAuditEvent(r, auditSchedule)
+	e.Detail = fmt.Sprintf("added schedule %d %q", sc.ID, sc.Name)
+	audit.record(e)
+	renderSchedules(w, r, fmt.Sprintf("Schedule %q added", sc.Name), true)
+}
+
+// handleScheduleChange deletes, enables or disables a schedule
+func handleScheduleChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	var sc schedule
+	var err error
+	action := r.FormValue("action")
+	switch action {
+	case "delete":
+		sc, err = schedules.remove(id)
+	case "enable", "disable":
+		sc, err = schedules.setEnabled(id, action == "enable", time.Now())
+	default:
+		err = fmt.Errorf("unknown action %q", action)
+	}
+	if err != nil {
+		renderSchedules(w, r, "Error: "+err.Error(), false)
+		return
+	}
+	e := newAuditEvent(r, auditSchedule)
+	e.Detail = fmt.Sprintf("%s schedule %d %q", action, sc.ID, sc.Name)
+	audit.record(e)
+	renderSchedules(w, r, fmt.Sprintf("Schedule %q: %sd", sc.Name, action), true)
+}
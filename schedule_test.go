@@ -0,0 +1,211 @@
+package main
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useSchedules swaps in schedules kept in a new directory for one test
+func useSchedules(t *testing.T) (*scheduleStore, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := loadSchedules(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := schedules
+	schedules = s
+	t.Cleanup(func() { schedules = saved })
+	return s, dir
+}
+
+func TestRenderMessage(t *testing.T) {
+	now := time.Date(2025, 3, 3, 7, 30, 0, 0, time.UTC)
+	tests := []struct {
+		message  string
+		expected string
+	}{
+		{"Chores", "Chores"},
+		{"Chores for {{.Weekday}} {{.Date}}", "Chores for Monday 2025-03-03"},
+		{"{{.Now.Format \"2 Jan\"}} at {{.Time}}", "3 Mar at 07:30"},
+		{"{{ .Date }}", "2025-03-03"},
+	}
+	for _, tt := range tests {
+		got, err := renderMessage(tt.message, now)
+		if err != nil || got != tt.expected {
+			t.Errorf("renderMessage(%q) = %q, %v, want %q", tt.message, got, err, tt.expected)
+		}
+	}
+	for _, bad := range []string{"{{.Weekday", "{{.Tomorrow}}", "{{range 2000000000}}x{{end}}", "{{.Now.Format 2}}"} {
+		if _, err := renderMessage(bad, now); err == nil {
+			t.Errorf("renderMessage(%q) = nil error", bad)
+		}
+	}
+}
+
+func TestAddScheduleErrors(t *testing.T) {
+	s, _ := useSchedules(t)
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		schedule schedule
+		expected string
+	}{
+		{"No name", schedule{Cron: "@daily", Message: "x"}, "name"},
+		{"No time", schedule{Name: "x", Message: "x"}, "cron expression or a time"},
+		{"Both times", schedule{Name: "x", Cron: "@daily", At: now.Add(time.Hour), Message: "x"}, "not both"},
+		{"Bad cron", schedule{Name: "x", Cron: "every day", Message: "x"}, "5 fields"},
+		{"Past", schedule{Name: "x", At: now.Add(-time.Hour), Message: "x"}, "has passed"},
+		{"Bad template", schedule{Name: "x", Cron: "@daily", Message: "{{.Day}}"}, "message"},
+		{"Empty message", schedule{Name: "x", Cron: "@daily", Message: " "}, "empty"},
+		{"Too many copies", schedule{Name: "x", Cron: "@daily", Message: "x", Copies: 99}, "copies"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.add(tt.schedule, now)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("add() error = %v, want it to mention %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSchedulesRun(t *testing.T) {
+	useRoles(t, testRoles)
+	useAudit(t)
+	s, dir := useSchedules(t)
+	var printed []string
+	q := useTestQueue(t, func(j *job) (time.Time, error) {
+		printed = append(printed, j.Labels[0].Message)
+		return time.Now(), nil
+	})
+	q.setPaused(true)
+
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.Local) // a Saturday
+	daily, err := s.add(schedule{Name: "Chores", Cron: "30 7 * * *", Message: "Chores for {{.Weekday}}", Owner: requester{User: "alice"}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	once, err := s.add(schedule{Name: "Bins", At: now.Add(2 * time.Hour), Message: "Bins out", Copies: 2, Owner: requester{User: "alice"}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused, err := s.add(schedule{Name: "Viewer", Cron: "0 8 * * *", Message: "Not allowed", Owner: requester{User: "carol"}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the token named alice has the viewer role, not alice's
+	token, err := s.add(schedule{Name: "Token", Cron: "0 8 * * *", Message: "Not allowed", Owner: requester{User: "alice", Token: true}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.runDue(now)
+	if q.depth() != 0 {
+		t.Fatalf("%d jobs queued before any schedule was due", q.depth())
+	}
+	s.runDue(now.Add(3 * time.Hour))
+	s.runDue(time.Date(2025, 3, 2, 7, 31, 0, 0, time.Local))
+	s.runDue(time.Date(2025, 3, 2, 8, 0, 0, 0, time.Local))
+
+	jobs := q.queued()
+	if len(jobs) != 2 {
+		t.Fatalf("queued %d jobs, want the one-off and one daily", len(jobs))
+	}
+	if jobs[0].Labels[0].Message != "Bins out" || jobs[0].Options.copies() != 2 || jobs[0].From.User != "alice" {
+		t.Errorf("one-off job = %+v", jobs[0])
+	}
+	if jobs[1].Labels[0].Message != "Chores for Sunday" {
+		t.Errorf("daily job message = %q, want Chores for Sunday", jobs[1].Labels[0].Message)
+	}
+
+	// reload from disk to check what was saved
+	loaded, err := loadSchedules(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := map[int]*schedule{}
+	for _, sc := range loaded.Schedules {
+		byID[sc.ID] = sc
+	}
+	if sc := byID[daily.ID]; !sc.Enabled || sc.Next.Format("2006-01-02 15:04") != "2025-03-03 07:30" || sc.LastJob != jobs[1].ID {
+		t.Errorf("saved daily schedule = %+v, want next on Monday", sc)
+	}
+	if sc := byID[once.ID]; sc.Enabled || !sc.Next.IsZero() || sc.LastJob != jobs[0].ID {
+		t.Errorf("saved one-off schedule = %+v, want it done", sc)
+	}
+	if sc := byID[refused.ID]; !strings.Contains(sc.LastError, "cannot print") {
+		t.Errorf("saved schedule of a viewer = %+v, want a role error", sc)
+	}
+	if sc := byID[token.ID]; !sc.Owner.Token || !strings.Contains(sc.LastError, "cannot print") {
+		t.Errorf("saved schedule of a token = %+v, want a role error", sc)
+	}
+	if loaded.NextID != 5 {
+		t.Errorf("saved next id = %d, want 5", loaded.NextID)
+	}
+}
+
+func TestScheduleLimits(t *testing.T) {
+	useRoles(t, testRoles)
+	useAudit(t)
+	s, _ := useSchedules(t)
+	q := useTestQueue(t, func(j *job) (time.Time, error) { return time.Now(), nil })
+	q.setPaused(true)
+	useLimits(t, limitsConfig{UserPerMinute: 2})
+
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.Local)
+	alice := requester{User: "alice", IP: "10.0.0.2"}
+	small, err := s.add(schedule{Name: "Small", At: now.Add(time.Hour), Message: "Milk", Copies: 2, Owner: alice}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big, err := s.add(schedule{Name: "Big", At: now.Add(time.Hour), Message: "Eggs", Copies: 3, Owner: alice}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.runDue(now.Add(time.Hour))
+
+	byID := map[int]schedule{}
+	for _, sc := range s.list() {
+		byID[sc.ID] = sc
+	}
+	if sc := byID[small.ID]; sc.LastError != "" || sc.LastJob == 0 {
+		t.Errorf("schedule within the limit = %+v, want it queued", sc)
+	}
+	if sc := byID[big.ID]; !strings.Contains(sc.LastError, "limit") {
+		t.Errorf("schedule over the limit = %+v, want a limit error", sc)
+	}
+	if q.depth() != 1 {
+		t.Errorf("queued %d jobs, want only the one within the limit", q.depth())
+	}
+}
+
+func TestSchedulePage(t *testing.T) {
+	useSchedules(t)
+	tmpl = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+	form := url.Values{"name": {"Chores"}, "cron": {"@daily"}, "message": {"Chores for {{.Weekday}}"}}
+	r := httptest.NewRequest("POST", "/schedules", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSchedules(w, withUser(r, "alice"))
+	if body := w.Body.String(); !strings.Contains(body, "Schedule &#34;Chores&#34; added") || !strings.Contains(body, "{{.Weekday}}") {
+		t.Errorf("adding a schedule gave %s", body)
+	}
+	list := schedules.list()
+	if len(list) != 1 || list[0].Owner.User != "alice" || list[0].Barcode != conf().Defaults.Barcode {
+		t.Fatalf("schedules = %+v, want Chores for alice", list)
+	}
+
+	form = url.Values{"id": {"1"}, "action": {"disable"}}
+	r = httptest.NewRequest("POST", "/schedules/change", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handleScheduleChange(httptest.NewRecorder(), r)
+	if list := schedules.list(); list[0].Enabled || !list[0].Next.IsZero() {
+		t.Errorf("disabled schedule = %+v", list[0])
+	}
+}
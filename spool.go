@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// errInterrupted is the error of a job that was printing when golabel
+// stopped.  It is not printed again by itself as it may have printed.
+const errInterrupted = "golabel stopped while this job was printing, so it may have printed; resubmit it if not"
+
+// jobSpool keeps the queue on disk, one JSON file per job, so queued jobs
+// survive a restart.  A job is written before it starts printing and again
+// when it finishes, and finished jobs are kept while they are in the
+// history so their idempotency keys are still known.
+type jobSpool struct {
+	dir string
+}
+
+// openSpool makes the spool directory in stateDir, returning nil without a
+// state directory
+func openSpool(stateDir string) (*jobSpool, error) {
+	if stateDir == "" {
+		return nil, nil
+	}
+	s := &jobSpool{dir: filepath.Join(stateDir, "spool")}
+	return s, os.MkdirAll(s.dir, 0o755)
+}
+
+// path is the file a job is kept in
+func (s *jobSpool) path(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("job-%08d.json", id))
+}
+
+// write saves a job, replacing its old file in one step once the new one is
+// safely on disk.  The directory is synced too so the rename itself
+// survives a power cut.
+func (s *jobSpool) write(j *job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp := s.path(j.ID) + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path(j.ID)); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// syncDir flushes a directory's entries to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// remove deletes a job's file
+func (s *jobSpool) remove(id int) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// load reads every job in the spool.  Files that are not jobs are reported
+// and skipped.
+func (s *jobSpool) load() ([]*job, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "job-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*job
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return jobs, err
+		}
+		j := &job{}
+		if err := json.Unmarshal(b, j); err != nil || j.ID == 0 {
+			fmt.Printf("Skipping spooled job %s: not a job file\n", name)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// spoolJob writes j to the spool, if there is one.  The caller must hold
+// q.mu.
+func (q *jobQueue) spoolJob(j *job) {
+	if q.spool == nil {
+		return
+	}
+	if err := q.spool.write(j); err != nil {
+		fmt.Printf("Error spooling job %d: %v\n", j.ID, err)
+	}
+}
+
+// unspool removes j from the spool, if there is one.  The caller must hold
+// q.mu.
+func (q *jobQueue) unspool(j *job) {
+	if q.spool == nil {
+		return
+	}
+	if err := q.spool.remove(j.ID); err != nil {
+		fmt.Printf("Error removing spooled job %d: %v\n", j.ID, err)
+	}
+}
+
+// restore loads the jobs left in a spool and keeps the queue there from
+// now on.  Queued jobs go back in the queue in order, with one being
+// retried first.  Failed jobs that were not dismissed go back on the failed
+// list, as does a job that was printing rather than printing twice.  It
+// must be called before the queue runs.
+func (q *jobQueue) restore(s *jobSpool, now time.Time) error {
+	jobs, err := s.load()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.spool = s
+	slices.SortFunc(jobs, func(a, b *job) int { return cmp.Compare(a.ID, b.ID) })
+	for _, j := range jobs {
+		j.done = make(chan struct{})
+		q.nextID = max(q.nextID, j.ID+1)
+		q.nextSeq = max(q.nextSeq, j.Seq+1)
+		switch j.State {
+		case jobQueued, jobRetrying:
+			q.pending = append(q.pending, j)
+			limits.reserve(j.From, j.PaperMM)
+			continue
+		case jobPrinting:
+			j.State = jobFailed
+			j.Err = errInterrupted
+			j.Finished = now
+			q.dead = append(q.dead, j)
+			q.spoolJob(j)
+		case jobFailed:
+			if !j.Dismissed {
+				q.dead = append(q.dead, j)
+			}
+		}
+		close(j.done)
+		q.recent = append(q.recent, j)
+	}
+	slices.SortStableFunc(q.pending, func(a, b *job) int {
+		switch {
+		case (a.State == jobRetrying) != (b.State == jobRetrying):
+			return boolOrder(a.State == jobRetrying)
+		case a.Options.Urgent != b.Options.Urgent:
+			return boolOrder(a.Options.Urgent)
+		}
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	byFinished := func(a, b *job) int { return a.Finished.Compare(b.Finished) }
+	slices.SortStableFunc(q.recent, byFinished)
+	slices.SortStableFunc(q.dead, byFinished)
+	q.trimDead()
+	q.trimRecent()
+	return err
+}
+
+// boolOrder sorts true before false
+func boolOrder(first bool) int {
+	if first {
+		return -1
+	}
+	return 1
+}
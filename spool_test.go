@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpoolRestoresQueue(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	alice := requester{User: "alice"}
+	s, err := openSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := newJobQueue()
+	if err := q.restore(s, now); err != nil {
+		t.Fatal(err)
+	}
+
+	done := q.submit(alice, []labelSpec{{"done", 1}}, labelOptions{})
+	j, _ := q.next(now)
+	q.finish(j, now, nil, retryConfig{MaxAttempts: 1})
+	midFlight := q.submit(alice, []labelSpec{{"mid-flight", 2}}, labelOptions{})
+	keyed, _ := q.submitKeyed("milk-1", alice, []labelSpec{{"keyed", 3}}, labelOptions{})
+	if j, _ := q.next(now); j != midFlight {
+		t.Fatalf("next() = %+v, want the mid-flight job", j)
+	}
+	urgent := q.submit(alice, []labelSpec{{"urgent", 4}}, labelOptions{})
+	if _, err := q.promote(urgent.ID); err != nil {
+		t.Fatal(err)
+	}
+	// golabel stops here, with the mid-flight job printing
+
+	restarted := newJobQueue()
+	if err := restarted.restore(s, now); err != nil {
+		t.Fatal(err)
+	}
+	var waiting []int
+	for _, j := range restarted.queued() {
+		waiting = append(waiting, j.ID)
+	}
+	if len(waiting) != 2 || waiting[0] != urgent.ID || waiting[1] != keyed.ID {
+		t.Errorf("restored queue = %v, want the urgent job then the keyed one", waiting)
+	}
+	dead := restarted.deadLetters()
+	if len(dead) != 1 || dead[0].ID != midFlight.ID || dead[0].Err != errInterrupted {
+		t.Errorf("restored failed jobs = %+v, want the mid-flight job interrupted", dead)
+	}
+	if old, ok := restarted.find(done.ID); !ok || old.State != jobPrinted {
+		t.Errorf("restored history = %+v, want the printed job", old)
+	}
+	if j, ok := restarted.findKeyed(alice, "milk-1"); !ok || j.ID != keyed.ID {
+		t.Errorf("findKeyed(alice) = %+v, want the keyed job", j)
+	}
+	if _, ok := restarted.findKeyed(requester{User: "bob"}, "milk-1"); ok {
+		t.Errorf("findKeyed(bob) found alice's job")
+	}
+	newJob := restarted.submit(alice, []labelSpec{{"new", 5}}, labelOptions{})
+	if newJob.ID != urgent.ID+1 {
+		t.Errorf("new job after a restart has id %d, want %d", newJob.ID, urgent.ID+1)
+	}
+
+	var printed []int
+	go restarted.run(func(j *job) (time.Time, error) {
+		printed = append(printed, j.ID)
+		return time.Now(), nil
+	})
+	if !restarted.wait(newJob, time.Second) {
+		t.Fatalf("restored jobs did not print")
+	}
+	if len(printed) != 3 || printed[0] != urgent.ID || printed[1] != keyed.ID {
+		t.Errorf("printed after the restart %v, want the urgent job, then the keyed one, then the new one", printed)
+	}
+
+	again := newJobQueue()
+	if err := again.restore(s, now); err != nil {
+		t.Fatal(err)
+	}
+	if again.depth() != 0 {
+		t.Errorf("%d jobs restored after the queue emptied", again.depth())
+	}
+}
+
+func TestSpoolRestoresFailedJobs(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	s, err := openSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := newJobQueue()
+	if err := q.restore(s, now); err != nil {
+		t.Fatal(err)
+	}
+	fail := func(message string) *job {
+		q.submit(requester{}, []labelSpec{{message, 1}}, labelOptions{})
+		j, _ := q.next(now)
+		q.finish(j, time.Time{}, errors.New("cutter error"), retryConfig{MaxAttempts: 1})
+		return j
+	}
+	dismissed := fail("dismissed")
+	kept := fail("kept")
+	if _, err := q.dismiss(dismissed.ID, func(*job) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// push both out of the history
+	for i := range recentJobs {
+		q.submit(requester{}, []labelSpec{{"printed", i}}, labelOptions{})
+		j, _ := q.next(now)
+		q.finish(j, now, nil, retryConfig{MaxAttempts: 1})
+	}
+	if _, err := os.Stat(s.path(dismissed.ID)); !os.IsNotExist(err) {
+		t.Errorf("spool file of a dismissed job not removed: %v", err)
+	}
+
+	restarted := newJobQueue()
+	if err := restarted.restore(s, now); err != nil {
+		t.Fatal(err)
+	}
+	dead := restarted.deadLetters()
+	if len(dead) != 1 || dead[0].ID != kept.ID || dead[0].Err != "cutter error" {
+		t.Errorf("restored failed jobs = %+v, want the one not dismissed", dead)
+	}
+	if _, err := restarted.dismiss(kept.ID, func(*job) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	again := newJobQueue()
+	if err := again.restore(s, now); err != nil {
+		t.Fatal(err)
+	}
+	if dead := again.deadLetters(); len(dead) != 0 {
+		t.Errorf("failed jobs restored after being dismissed: %+v", dead)
+	}
+}
+
+func TestAPIPrintIdempotencyKey(t *testing.T) {
+	var printed int
+	useTestQueue(t, func(j *job) (time.Time, error) {
+		printed++
+		return time.Now(), nil
+	})
+	var ids []int
+	for _, send := range []func(*httptest.ResponseRecorder){
+		func(w *httptest.ResponseRecorder) {
+			handleAPIPrint(w, httptest.NewRequest("POST", "/api/print", strings.NewReader(`{"message":"Milk","key":"milk-1"}`)))
+		},
+		func(w *httptest.ResponseRecorder) {
+			r := httptest.NewRequest("POST", "/api/print", strings.NewReader(`{"message":"Milk"}`))
+			r.Header.Set("Idempotency-Key", "milk-1")
+			handleAPIPrint(w, r)
+		},
+	} {
+		w := httptest.NewRecorder()
+		send(w)
+		var got jobSummary
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != 200 {
+			t.Fatalf("POST /api/print = %d %s", w.Code, w.Body.String())
+		}
+		if got.Key != "milk-1" || got.State != jobPrinted {
+			t.Errorf("POST /api/print = %+v, want printed with the key", got)
+		}
+		ids = append(ids, got.ID)
+	}
+	if ids[0] != ids[1] || printed != 1 {
+		t.Errorf("sending a key twice gave jobs %v and printed %d, want one job printed once", ids, printed)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drummonds/golabel/version"
+)
+
+// Real time status requests (DLE EOT n)
+const (
+	statusPrinter    = 1 // online / offline
+	statusOffline    = 2 // cause of going offline
+	statusError      = 3 // cause of an error
+	statusPaperRoll  = 4 // roll paper sensors
+	statusFixedMask  = 0x93
+	statusFixedValue = 0x12 // bits 1 and 4 are always set, 0 and 7 clear
+)
+
+// printerStatus is what the printer reported the last time it was asked
+type printerStatus struct {
+	Connected       bool      `json:"connected"`
+	Known           bool      `json:"known"` // false if the printer did not answer
+	Offline         bool      `json:"offline"`
+	CoverOpen       bool      `json:"coverOpen"`
+	PaperEnd        bool      `json:"paperEnd"`
+	PaperNearEnd    bool      `json:"paperNearEnd"`
+	CutterError     bool      `json:"cutterError"`
+	Unrecoverable   bool      `json:"unrecoverableError"`
+	AutoRecoverable bool      `json:"autoRecoverableError"`
+	Error           string    `json:"error,omitempty"`
+	Checked         time.Time `json:"checked"`
+}
+
+var (
+	statusMu   sync.Mutex
+	lastStatus printerStatus
+)
+
+// parseStatusByte sets the fields of s from the reply to DLE EOT n
+func (s *printerStatus) parseStatusByte(n int, b byte) {
+	switch n {
+	case statusPrinter:
+		s.Offline = b&0x08 != 0
+	case statusOffline:
+		s.CoverOpen = b&0x04 != 0
+		s.PaperEnd = s.PaperEnd || b&0x20 != 0
+	case statusError:
+		s.CutterError = b&0x08 != 0
+		s.Unrecoverable = b&0x20 != 0
+		s.AutoRecoverable = b&0x40 != 0
+	case statusPaperRoll:
+		s.PaperNearEnd = b&0x0C != 0
+		s.PaperEnd = s.PaperEnd || b&0x60 != 0
+	}
+}
+
+// problems lists what is wrong with the printer, worst first
+func (s printerStatus) problems() []string {
+	var result []string
+	if !s.Connected {
+		return []string{"disconnected"}
+	}
+	if !s.Known {
+		if s.Error != "" {
+			return []string{"status unknown: " + s.Error}
+		}
+		return []string{"status unknown"}
+	}
+	flags := []struct {
+		set  bool
+		text string
+	}{
+		{s.Unrecoverable, "unrecoverable error"},
+		{s.CutterError, "cutter error"},
+		{s.CoverOpen, "cover open"},
+		{s.PaperEnd, "out of paper"},
+		{s.AutoRecoverable, "overheated"},
+		{s.Offline, "offline"},
+		{s.PaperNearEnd, "paper near end"},
+	}
+	for _, f := range flags {
+		if f.set {
+			result = append(result, f.text)
+		}
+	}
+	return result
+}
+
+// Ready reports whether the printer can print.  A printer that does not
+// answer status requests is not ready, paper near end is only a warning.
+func (s printerStatus) Ready() bool {
+	return s.Connected && s.Known && !(s.Offline || s.CoverOpen || s.PaperEnd || s.CutterError ||
+		s.Unrecoverable || s.AutoRecoverable)
+}
+
+// Summary describes the printer status for the web page
+func (s printerStatus) Summary() string {
+	problems := s.problems()
+	if len(problems) == 0 {
+		return "Ready"
+	}
+	return strings.Join(problems, ", ")
+}
+
+// pollStatus asks the printer for all four status bytes.  The caller must
+// hold printMu.
+func pollStatus() printerStatus {
+	s := printerStatus{Checked: time.Now(), Connected: printerConnected()}
+	if s.Connected {
+		s.Known = true
+		for n := statusPrinter; n <= statusPaperRoll; n++ {
+			b, err := dev.request(0x10, 0x04, byte(n))
+			if err == nil && b&statusFixedMask != statusFixedValue {
+				err = errNoReply
+			}
+			if err != nil {
+				s = printerStatus{Checked: s.Checked, Connected: printerConnected(), Error: err.Error()}
+				break
+			}
+			s.parseStatusByte(n, b)
+		}
+	}
+
+	statusMu.Lock()
+	lastStatus = s
+	statusMu.Unlock()
+	return s
+}
+
+// checkStatus polls the printer when it is not busy printing, otherwise it
+// returns the last status read
+func checkStatus() printerStatus {
+	if !printMu.TryLock() {
+		statusMu.Lock()
+		defer statusMu.Unlock()
+		return lastStatus
+	}
+	defer printMu.Unlock()
+	return pollStatus()
+}
+
+// handleStatus returns the printer status as JSON
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := checkStatus()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Version string        `json:"version"`
+		Ready   bool          `json:"ready"`
+		Summary string        `json:"summary"`
+		Printer printerStatus `json:"printer"`
+	}{
+		Version: version.GetVersion(),
+		Ready:   status.Ready(),
+		Summary: status.Summary(),
+		Printer: status,
+	})
+}
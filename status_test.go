@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseStatusByte(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		b        byte
+		expected printerStatus
+	}{
+		{"Online", statusPrinter, 0x12, printerStatus{}},
+		{"Offline", statusPrinter, 0x1A, printerStatus{Offline: true}},
+		{"Cover open", statusOffline, 0x16, printerStatus{CoverOpen: true}},
+		{"Stopped at paper end", statusOffline, 0x32, printerStatus{PaperEnd: true}},
+		{"Cutter error", statusError, 0x1A, printerStatus{CutterError: true}},
+		{"Unrecoverable error", statusError, 0x32, printerStatus{Unrecoverable: true}},
+		{"Overheated", statusError, 0x52, printerStatus{AutoRecoverable: true}},
+		{"Paper near end", statusPaperRoll, 0x1E, printerStatus{PaperNearEnd: true}},
+		{"Paper end", statusPaperRoll, 0x72, printerStatus{PaperEnd: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s printerStatus
+			s.parseStatusByte(tt.n, tt.b)
+			if s != tt.expected {
+				t.Errorf("parseStatusByte(%d, %#x) = %+v, want %+v", tt.n, tt.b, s, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrinterStatusReady(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   printerStatus
+		ready    bool
+		expected string
+	}{
+		{"All clear", printerStatus{Connected: true, Known: true}, true, "Ready"},
+		{"Near end is a warning", printerStatus{Connected: true, Known: true, PaperNearEnd: true}, true, "paper near end"},
+		{"Out of paper", printerStatus{Connected: true, Known: true, PaperEnd: true, Offline: true}, false, "out of paper, offline"},
+		{"Not answering", printerStatus{Connected: true, Error: "no reply from printer"}, false, "status unknown: no reply from printer"},
+		{"Disconnected", printerStatus{}, false, "disconnected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.status.Ready(); got != tt.ready {
+				t.Errorf("Ready() = %v, want %v", got, tt.ready)
+			}
+			if got := tt.status.Summary(); got != tt.expected {
+				t.Errorf("Summary() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPollStatus(t *testing.T) {
+	f := useFakePrinter(t)
+	f.status[2] = 0x36 // cover open, stopped at paper end
+	f.status[4] = 0x7E // near end and paper end
+
+	s := pollStatus()
+	if !s.Known || !s.CoverOpen || !s.PaperEnd || !s.PaperNearEnd || s.Offline {
+		t.Errorf("pollStatus() = %+v", s)
+	}
+	if s.Ready() {
+		t.Errorf("pollStatus() reported ready with the cover open")
+	}
+
+	delete(f.status, 3)
+	if s := pollStatus(); s.Known || s.Error == "" {
+		t.Errorf("pollStatus() with a missing reply = %+v, want unknown with an error", s)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// superviseInterval is how often the supervisor looks for the printer
+const superviseInterval = 2 * time.Second
+
+// supervisePrinter watches for the printer being unplugged or power cycled.
+// It drops a connection whose device has failed or disappeared from /dev and
+// reopens the printer when it shows up again, waking the job queue.  It also
+// moves to a different device when the config is changed.
+func supervisePrinter(q *jobQueue) {
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if checkPrinter(conf().Printer.Device) {
+			q.wakeUp()
+		}
+	}
+}
+
+// checkPrinter runs one round of supervision and reports whether the printer
+// has just been reconnected
+func checkPrinter(wantPath string) bool {
+	printMu.Lock()
+	defer printMu.Unlock()
+
+	if dev != nil {
+		_, err := os.Stat(devicePath)
+		moved := wantPath != "" && wantPath != devicePath
+		if printerConnected() && err == nil && !moved {
+			return false
+		}
+		fmt.Printf("Printer %s disconnected\n", devicePath)
+		closePrinter()
+	}
+
+	if err := openPrinter(wantPath); err != nil {
+		return false
+	}
+	fmt.Printf("Printer %s connected\n", devicePath)
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPrinterReconnects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lp0")
+	t.Cleanup(func() {
+		printMu.Lock()
+		closePrinter()
+		printMu.Unlock()
+	})
+
+	if checkPrinter(path) {
+		t.Fatalf("checkPrinter() connected to a missing device")
+	}
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !checkPrinter(path) {
+		t.Fatalf("checkPrinter() did not connect when the device appeared")
+	}
+	if checkPrinter(path) {
+		t.Errorf("checkPrinter() reconnected an already connected device")
+	}
+
+	os.Remove(path)
+	if checkPrinter(path) || dev != nil {
+		t.Fatalf("checkPrinter() kept the device after it was removed")
+	}
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !checkPrinter(path) || dev == nil {
+		t.Errorf("checkPrinter() did not reconnect when the device came back")
+	}
+}
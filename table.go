@@ -0,0 +1,250 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mect/go-escpos"
+)
+
+// A table block is a run of message lines starting with '|', in a markdown
+// like style:
+//
+//	| Item | Qty | Price |
+//	|:--.  | --4:| --:   |
+//	| Bolts M6 | 4 | 1.20 |
+//
+// The optional second row is a column spec. Each spec cell is made of dashes
+// with a leading ':' for left, trailing ':' for right or both for centre
+// alignment.  A number in the cell fixes the column width and a '.' fills
+// the padding after the cell with dot leaders.  Columns without a fixed width
+// share out what is left of the line and cell text wraps within its column.
+
+// column describes how one column of a table block is laid out
+type column struct {
+	width  int // width in printed characters, 0 means size to fit
+	align  escpos.Alignment
+	leader bool // pad with dots rather than spaces
+}
+
+// columnGap is the number of spaces between adjacent columns
+const columnGap = 1
+
+// minColumnWidth is the narrowest an automatic column is shrunk to.  Any
+// narrower and a word broken with a hyphen would not fit in it.
+const minColumnWidth = 3
+
+// isTableRow reports whether a message line is part of a table block
+func isTableRow(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "|")
+}
+
+// splitTableRow splits a table line into its trimmed cells
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// parseColumnSpec parses a single spec cell such as ":--12." or "--:".
+// It returns false if the cell is not a column spec.
+func parseColumnSpec(cell string) (column, bool) {
+	col := column{align: escpos.AlignLeft}
+	if cell == "" {
+		return col, false
+	}
+	left := strings.HasPrefix(cell, ":")
+	right := len(cell) > 1 && strings.HasSuffix(cell, ":")
+	cell = strings.TrimPrefix(cell, ":")
+	if right {
+		cell = strings.TrimSuffix(cell, ":")
+	}
+	switch {
+	case left && right:
+		col.align = escpos.AlignCenter
+	case right:
+		col.align = escpos.AlignRight
+	}
+
+	var digits strings.Builder
+	hasDash := false
+	for _, r := range cell {
+		switch {
+		case r == '-':
+			hasDash = true
+		case r == '.':
+			col.leader = true
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		default:
+			return col, false
+		}
+	}
+	if !hasDash {
+		return col, false
+	}
+	if digits.Len() > 0 {
+		col.width, _ = strconv.Atoi(digits.String())
+	}
+	return col, true
+}
+
+// parseSpecRow returns the column specs if every cell of the row is a spec
+func parseSpecRow(cells []string) ([]column, bool) {
+	cols := make([]column, len(cells))
+	for i, cell := range cells {
+		col, ok := parseColumnSpec(cell)
+		if !ok {
+			return nil, false
+		}
+		cols[i] = col
+	}
+	return cols, true
+}
+
+// fitColumns fills in the width of every automatic column so that the table
+// fits within maxWidth.  Automatic columns start at the width of their widest
+// cell and the widest is repeatedly narrowed, down to minColumnWidth, until
+// the row fits.
+func fitColumns(cols []column, rows [][]string, maxWidth int) []int {
+	widths := make([]int, len(cols))
+	auto := make([]bool, len(cols))
+	used := columnGap * (len(cols) - 1)
+	for i, col := range cols {
+		if col.width > 0 {
+			widths[i] = col.width
+			used += col.width
+			continue
+		}
+		auto[i] = true
+		for _, row := range rows {
+			if i < len(row) {
+				widths[i] = max(widths[i], runesWidth([]rune(row[i])))
+			}
+		}
+		widths[i] = max(widths[i], 1)
+		used += widths[i]
+	}
+
+	for used > maxWidth {
+		widest := -1
+		for i := range widths {
+			if auto[i] && widths[i] > minColumnWidth && (widest < 0 || widths[i] > widths[widest]) {
+				widest = i
+			}
+		}
+		if widest < 0 {
+			break // nothing left to narrow, let the printer wrap
+		}
+		widths[widest]--
+		used--
+	}
+	return widths
+}
+
+// padCell pads text out to width printed characters using the given
+// alignment.  Leader columns are padded with dots.
+func padCell(text string, width int, col column) string {
+	gap := width - runesWidth([]rune(text))
+	if gap <= 0 {
+		return text
+	}
+	fill := " "
+	if col.leader {
+		fill = "."
+	}
+	switch col.align {
+	case escpos.AlignRight:
+		return strings.Repeat(fill, gap) + text
+	case escpos.AlignCenter:
+		before := gap / 2
+		return strings.Repeat(" ", before) + text + strings.Repeat(" ", gap-before)
+	default:
+		return text + strings.Repeat(fill, gap)
+	}
+}
+
+// layoutRow lays out one table row, wrapping each cell within its column
+func layoutRow(cells []string, cols []column, widths []int) []string {
+	wrapped := make([][]string, len(cols))
+	levels := make([]int, len(cols))
+	height := 1
+	for i := range cols {
+		text := ""
+		if i < len(cells) {
+			text = cells[i]
+		}
+		wrapped[i] = wrapSingleLine(text, widths[i])
+		levels[i] = textLevel(text)
+		height = max(height, len(wrapped[i]))
+	}
+
+	lines := make([]string, height)
+	for l := range lines {
+		var sb strings.Builder
+		for i, col := range cols {
+			text := ""
+			if l < len(wrapped[i]) {
+				text = wrapped[i][l]
+			}
+			// only the last line of a cell gets its leader
+			if l != len(wrapped[i])-1 {
+				col.leader = false
+			}
+			sb.WriteString(padCell(visualOrder(text, levels[i]), widths[i], col))
+			if i < len(cols)-1 {
+				sb.WriteString(strings.Repeat(" ", columnGap))
+			}
+		}
+		lines[l] = strings.TrimRight(sb.String(), " ")
+	}
+	return lines
+}
+
+// layoutTable lays out a block of table lines into printable lines no wider
+// than maxWidth.  The spec row may be the first row, or the second in which
+// case the row above it is a header and is ruled off.
+func layoutTable(block []string, maxWidth int) []string {
+	var rows [][]string
+	var cols []column
+	header := false
+	for i, line := range block {
+		cells := splitTableRow(line)
+		if i <= 1 && cols == nil {
+			if spec, ok := parseSpecRow(cells); ok {
+				cols = spec
+				header = i == 1
+				continue
+			}
+		}
+		rows = append(rows, cells)
+	}
+
+	numCols := len(cols)
+	for _, row := range rows {
+		numCols = max(numCols, len(row))
+	}
+	for len(cols) < numCols {
+		cols = append(cols, column{align: escpos.AlignLeft})
+	}
+
+	widths := fitColumns(cols, rows, maxWidth)
+	total := columnGap * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+
+	var lines []string
+	for i, row := range rows {
+		lines = append(lines, layoutRow(row, cols, widths)...)
+		if header && i == 0 {
+			lines = append(lines, strings.Repeat("-", min(total, maxWidth)))
+		}
+	}
+	return lines
+}
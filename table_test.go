@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mect/go-escpos"
+)
+
+func TestParseColumnSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		cell     string
+		expected column
+		ok       bool
+	}{
+		{"Default left", "---", column{align: escpos.AlignLeft}, true},
+		{"Explicit left", ":--", column{align: escpos.AlignLeft}, true},
+		{"Right", "--:", column{align: escpos.AlignRight}, true},
+		{"Centre", ":-:", column{align: escpos.AlignCenter}, true},
+		{"Fixed width", "--12", column{width: 12, align: escpos.AlignLeft}, true},
+		{"Leader", ":--.", column{align: escpos.AlignLeft, leader: true}, true},
+		{"Width and right", "-4:", column{width: 4, align: escpos.AlignRight}, true},
+		{"Plain number", "12", column{}, false},
+		{"Text", "Item", column{}, false},
+		{"Empty", "", column{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			col, ok := parseColumnSpec(tt.cell)
+			if ok != tt.ok {
+				t.Fatalf("parseColumnSpec(%q) ok = %v, want %v", tt.cell, ok, tt.ok)
+			}
+			if ok && col != tt.expected {
+				t.Errorf("parseColumnSpec(%q) = %+v, want %+v", tt.cell, col, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLayoutTable(t *testing.T) {
+	tests := []struct {
+		name     string
+		block    []string
+		maxWidth int
+		expected []string
+	}{
+		{
+			name:     "Auto widths",
+			block:    []string{"| Bolts | 4 |", "| Washers | 12 |"},
+			maxWidth: 32,
+			expected: []string{"Bolts   4", "Washers 12"},
+		},
+		{
+			name:     "Header and alignment",
+			block:    []string{"| Item | Qty |", "|:--|--:|", "| Nut | 4 |"},
+			maxWidth: 32,
+			expected: []string{"Item Qty", "--------", "Nut    4"},
+		},
+		{
+			name:     "Dot leaders",
+			block:    []string{"|:--10.|--:|", "| Nuts | 1.20 |"},
+			maxWidth: 32,
+			expected: []string{"Nuts...... 1.20"},
+		},
+		{
+			name:     "Wrapping within a column",
+			block:    []string{"| Hex head bolt | 4 |"},
+			maxWidth: 10,
+			expected: []string{"Hex head 4", "bolt"},
+		},
+		{
+			name:     "Full-width characters keep columns aligned",
+			block:    []string{"| 世界 | 1 |", "| ab | 2 |"},
+			maxWidth: 32,
+			expected: []string{"世界 1", "ab   2"},
+		},
+		{
+			name:     "Columns are not narrowed past a broken word",
+			block:    []string{"| abcdef | ghijkl | 4 |"},
+			maxWidth: 9,
+			expected: []string{"ab- gh- 4", "cd- ij-", "ef  kl"},
+		},
+		{
+			name:     "Short rows are padded",
+			block:    []string{"| a | b | c |", "| d |"},
+			maxWidth: 32,
+			expected: []string{"a b c", "d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := layoutTable(tt.block, tt.maxWidth)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("layoutTable(%q, %d) = %q, want %q", tt.block, tt.maxWidth, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLayoutMessageWithTable(t *testing.T) {
+	message := "Parts\n| Bolt | 4 |\n| Nut | 8 |\nThanks"
+	expected := []string{"Parts", "Bolt 4", "Nut  8", "Thanks"}
+	result := layoutMessage(message, 32)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("layoutMessage(%q) = %q, want %q", message, result, expected)
+	}
+
+	// tabs in cells are expanded before the columns are measured
+	message = "| Bolt\tM6 | 4 |\n| Nut | 8 |"
+	expected = []string{"Bolt  M6 4", "Nut      8"}
+	if result := layoutMessage(message, 32); !reflect.DeepEqual(result, expected) {
+		t.Errorf("layoutMessage(%q) = %q, want %q", message, result, expected)
+	}
+}
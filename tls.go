@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS modes
+const (
+	tlsOff        = "off"
+	tlsFiles      = "files"       // cert_file and key_file, from any CA
+	tlsSelfSigned = "self-signed" // made by golabel and kept in the state directory
+)
+
+// Self-signed certificate lifetime, renewed when it gets close to expiring
+const (
+	selfSignedLifetime = 2 * 365 * 24 * time.Hour
+	selfSignedRenew    = 30 * 24 * time.Hour
+)
+
+// certStore serves a certificate from files, loading it again when the files
+// change so a renewed certificate is picked up without a restart
+type certStore struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func newCertStore(certFile, keyFile string) (*certStore, error) {
+	s := &certStore{certFile: certFile, keyFile: keyFile}
+	if _, err := s.getCertificate(nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// getCertificate is used as tls.Config.GetCertificate.  If a changed
+// certificate cannot be loaded the old one is kept.
+func (s *certStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := os.Stat(s.certFile)
+	if err == nil && s.cert != nil && !info.ModTime().After(s.modified) {
+		return s.cert, nil
+	}
+	cert, loadErr := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if loadErr != nil {
+		if s.cert != nil {
+			return s.cert, nil
+		}
+		return nil, loadErr
+	}
+	if s.cert != nil {
+		fmt.Println("TLS certificate reloaded from", s.certFile)
+	}
+	s.cert = &cert
+	if err == nil {
+		s.modified = info.ModTime()
+	}
+	return s.cert, nil
+}
+
+// selfSignedHosts are the names and addresses put in a self-signed
+// certificate: the host name, localhost and the machine's addresses
+func selfSignedHosts() []string {
+	hosts := []string{"localhost"}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			hosts = append(hosts, ipNet.IP.String())
+		}
+	}
+	return hosts
+}
+
+// ensureSelfSigned returns the self-signed certificate files in dir, making
+// a new certificate for hosts if there is none or it is about to expire
+func ensureSelfSigned(dir string, hosts []string, now time.Time) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, "tls-cert.pem")
+	keyFile = filepath.Join(dir, "tls-key.pem")
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if now.Add(selfSignedRenew).Before(cert.Leaf.NotAfter) {
+			return certFile, keyFile, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"golabel"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", err
+	}
+	if err := writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0o600); err != nil {
+		return "", "", err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0o644); err != nil {
+		return "", "", err
+	}
+	fmt.Println("Made a self-signed TLS certificate in", certFile)
+	return certFile, keyFile, nil
+}
+
+// writePEM writes one PEM block to path, replacing the old file in one step
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// redirectToHTTPS sends plain HTTP requests to the HTTPS port.  Health
+// checks and metrics are still answered so monitoring does not need the
+// certificate.
+func redirectToHTTPS(httpsPort int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			next.ServeHTTP(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		switch {
+		case httpsPort != 443:
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		case strings.Contains(host, ":"):
+			host = "[" + host + "]" // an IPv6 address
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// readHeaderTimeout is how long a client has to send the request headers,
+// so slow clients cannot hold connections open
+const readHeaderTimeout = 10 * time.Second
+
+// newServer returns a web server for handler on port
+func newServer(port int, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+}
+
+// serve runs the web server as the config says, on plain HTTP or on HTTPS
+// with plain HTTP redirecting to it
+func serve(s serverConfig, handler http.Handler) error {
+	if s.TLS == tlsOff {
+		return newServer(s.Port, handler).ListenAndServe()
+	}
+
+	certFile, keyFile := s.CertFile, s.KeyFile
+	if s.TLS == tlsSelfSigned {
+		var err error
+		certFile, keyFile, err = ensureSelfSigned(s.StateDir, selfSignedHosts(), time.Now())
+		if err != nil {
+			return fmt.Errorf("making self-signed certificate: %w", err)
+		}
+	}
+	certs, err := newCertStore(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	plain := handler
+	if s.RedirectHTTP {
+		plain = redirectToHTTPS(s.HTTPSPort, handler)
+	}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- newServer(s.Port, plain).ListenAndServe()
+	}()
+	go func() {
+		server := newServer(s.HTTPSPort, handler)
+		server.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate, MinVersion: tls.VersionTLS12}
+		errs <- server.ListenAndServeTLS("", "")
+	}()
+	return <-errs
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile, err := ensureSelfSigned(dir, []string{"golabel.lan", "192.168.1.20"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Leaf.VerifyHostname("golabel.lan"); err != nil {
+		t.Errorf("certificate not valid for golabel.lan: %v", err)
+	}
+	if err := cert.Leaf.VerifyHostname("192.168.1.20"); err != nil {
+		t.Errorf("certificate not valid for its IP address: %v", err)
+	}
+	if info, _ := os.Stat(keyFile); info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// kept while it has a while to run
+	ensureSelfSigned(dir, []string{"golabel.lan"}, now.Add(time.Hour))
+	same, _ := tls.LoadX509KeyPair(certFile, keyFile)
+	if same.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Error("certificate replaced while still valid")
+	}
+
+	// renewed when close to expiring
+	ensureSelfSigned(dir, []string{"golabel.lan"}, cert.Leaf.NotAfter.Add(-time.Hour))
+	renewed, _ := tls.LoadX509KeyPair(certFile, keyFile)
+	if renewed.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) == 0 {
+		t.Error("certificate not renewed before expiring")
+	}
+}
+
+func TestCertStoreReloads(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := ensureSelfSigned(dir, []string{"one.lan"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := newCertStore(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := store.getCertificate(nil)
+
+	// a renewed certificate is picked up at the next handshake
+	os.Remove(certFile)
+	ensureSelfSigned(dir, []string{"two.lan"}, time.Now())
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	second, _ := store.getCertificate(nil)
+	if second == first || second.Leaf.VerifyHostname("two.lan") != nil {
+		t.Error("certificate not reloaded after the files changed")
+	}
+
+	// a broken file keeps the old certificate
+	os.WriteFile(certFile, []byte("junk"), 0o644)
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if third, err := store.getCertificate(nil); err != nil || third != second {
+		t.Errorf("broken certificate file replaced the good one: %v", err)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	tests := []struct {
+		name     string
+		port     int
+		url      string
+		code     int
+		location string
+	}{
+		{"Default port", 443, "http://golabel.lan/print?x=1", http.StatusMovedPermanently, "https://golabel.lan/print?x=1"},
+		{"Other port", 8443, "http://golabel.lan:8080/", http.StatusMovedPermanently, "https://golabel.lan:8443/"},
+		{"IPv6 default port", 443, "http://[::1]:80/x", http.StatusMovedPermanently, "https://[::1]/x"},
+		{"IPv6 other port", 8443, "http://[::1]:80/x", http.StatusMovedPermanently, "https://[::1]:8443/x"},
+		{"Health check", 443, "http://golabel.lan/healthz", http.StatusTeapot, ""},
+		{"Metrics", 443, "http://golabel.lan/metrics", http.StatusTeapot, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			redirectToHTTPS(tt.port, next).ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+			if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+				t.Errorf("GET %s = %d to %q, want %d to %q", tt.url, w.Code, w.Header().Get("Location"), tt.code, tt.location)
+			}
+		})
+	}
+}